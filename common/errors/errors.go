@@ -50,6 +50,13 @@ var (
 	FieldFormatting    = Create(http.StatusBadRequest, "fieldformat", "The request has one or more fields with an invalid format", "")
 	MailerDisabled     = Create(http.StatusForbidden, "mailerdisabled", "The mailing system is disabled", "No actions that require sending mails can be completed")
 	Internal           = Create(http.StatusInternalServerError, "internalerror", "An unexpected error occured on the server", "")
+	ChannelNotFound    = Create(http.StatusNotFound, "channelnotfound", "The given channel is not open on the network", "")
+	NotConnected       = Create(http.StatusConflict, "notconnected", "The network is not connected", "Connect to the network first")
+	UnknownCommand     = Create(http.StatusBadRequest, "unknowncommand", "The command type is not known", "")
+	UnsupportedVersion = Create(http.StatusBadRequest, "unsupportedversion", "The protocol version is not supported", "")
+	CertNotFound       = Create(http.StatusNotFound, "certnotfound", "The network doesn't have a client certificate", "Generate or upload one first")
+	InvalidCert        = Create(http.StatusBadRequest, "invalidcert", "The request doesn't contain a valid PEM encoded certificate and private key", "")
+	RequestTooLarge    = Create(http.StatusRequestEntityTooLarge, "requesttoolarge", "The request body is too large", "")
 )
 
 // Create a custom error
@@ -57,6 +64,12 @@ func Create(status int, simple, human, extra string) WebError {
 	return WebError{HTTP: status, Simple: simple, Human: human, ExtraInfo: extra}
 }
 
+// WithExtraInfo returns a copy of the error with the given extra information
+func (err WebError) WithExtraInfo(extra string) WebError {
+	err.ExtraInfo = extra
+	return err
+}
+
 func (err WebError) Error() string {
 	if len(err.ExtraInfo) > 0 {
 		return fmt.Sprintf("%s: %s. %s (HTTP %d)", err.Simple, err.Human, err.ExtraInfo, err.HTTP)
@@ -18,8 +18,9 @@
 package messages
 
 import (
-	"encoding/json"
-	"strconv"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/errors"
 )
 
 // Message types
@@ -38,49 +39,86 @@ const (
 	MsgMode       = "mode"
 	MsgClose      = "close"
 	MsgOpen       = "open"
+	MsgSearch     = "search"
+	MsgReadMarker = "readmarker"
+	MsgUnread     = "unread"
+	MsgResume     = "resume"
+	MsgResponse   = "response"
+	MsgError      = "error"
+	MsgHello      = "hello"
+	MsgNetEvent   = "netevent"
 )
 
 // Container is a basic wrapper for a type string and the actual message object
 type Container struct {
 	Type   string      `json:"type"`
 	Object interface{} `json:"object"`
+	// Seq is the sequence number of the message in the stream all clients of
+	// the user receive. Zero for messages sent to a single client.
+	Seq uint64 `json:"seq,omitempty"`
+	// ID is an optional identifier the client can give to commands. Responses
+	// and errors to the command have the same ID.
+	ID string `json:"id,omitempty"`
+}
+
+// Response tells the client that the command with the ID of the container succeeded
+type Response struct {
+	Command string      `json:"command"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// ErrorResponse tells the client that the command with the ID of the container failed
+type ErrorResponse struct {
+	Command string `json:"command"`
+	errors.WebError
+}
+
+// Resume tells a client which messages it missed while it was disconnected were replayed
+type Resume struct {
+	// Epoch and Seq are the epoch of the message stream and the sequence number
+	// of the last message sent before the live stream started.
+	Epoch int64  `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+	// Source is memory if all missed messages were replayed, database if only
+	// chat messages were replayed from the history or none if nothing was replayed.
+	Source   string `json:"source"`
+	Replayed int    `json:"replayed"`
+	// Complete is false if there were more missed messages than could be replayed.
+	Complete bool `json:"complete"`
 }
 
+// Resume sources
+const (
+	ResumeMemory   = "memory"
+	ResumeDatabase = "database"
+	ResumeNone     = "none"
+)
+
 // Message wraps an IRC message
 type Message struct {
-	ID        int64    `json:"id,omitempty"`
-	Network   string   `json:"network"`
-	Channel   string   `json:"channel"`
+	ID      int64  `json:"id,omitempty"`
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+	// Timestamp is the unix time of the message in milliseconds. When sent to clients
+	// in protocol versions before ProtocolMilliseconds it's in seconds.
 	Timestamp int64    `json:"timestamp,omitempty"`
 	Sender    string   `json:"sender,omitempty"`
 	Command   string   `json:"command"`
 	Message   string   `json:"message"`
 	OwnMsg    bool     `json:"ownmsg,omitempty"`
 	Preview   *Preview `json:"preview,omitempty"`
+	// Tags contains the IRCv3 message tags the message was received with
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
-// ParseMessage parses a Message object from a generic object
-func ParseMessage(obj interface{}) (msg Message) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	id, _ := mp["id"].(json.Number)
-	msg.ID, _ = strconv.ParseInt(string(id), 10, 64)
-	timestamp, _ := mp["timestamp"].(json.Number)
-	msg.Timestamp, _ = strconv.ParseInt(string(timestamp), 10, 64)
-	msg.Network, _ = mp["network"].(string)
-	msg.Channel, _ = mp["channel"].(string)
-	msg.Sender, _ = mp["sender"].(string)
-	msg.Command, _ = mp["command"].(string)
-	msg.Message, _ = mp["message"].(string)
-	msg.OwnMsg, _ = mp["ownmsg"].(bool)
-	pw, ok := mp["preview"]
-	if ok {
-		msg.Preview = ParsePreview(pw)
-	}
-	return
+// UnixMilli converts the given time into a unix timestamp in milliseconds, the unit of message timestamps
+func UnixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Time gets the time of the message
+func (msg Message) Time() time.Time {
+	return time.Unix(msg.Timestamp/1000, msg.Timestamp%1000*int64(time.Millisecond))
 }
 
 // RawMessage is a raw IRC message
@@ -89,36 +127,12 @@ type RawMessage struct {
 	Message string `json:"message"`
 }
 
-// ParseRawMessage parses a RawMessage object from a generic object
-func ParseRawMessage(obj interface{}) (msg RawMessage) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Message, _ = mp["message"].(string)
-	return
-}
-
 // NickChange is the message for IRC nick changes
 type NickChange struct {
 	Network string `json:"network"`
 	Nick    string `json:"nick"`
 }
 
-// ParseNickChange parses a NickChange object from a generic object
-func ParseNickChange(obj interface{}) (msg NickChange) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Nick, _ = mp["nick"].(string)
-	return
-}
-
 // NetData contains basic network data
 type NetData struct {
 	Name      string `json:"name"`
@@ -129,26 +143,17 @@ type NetData struct {
 	Port      uint16 `json:"port"`
 	SSL       bool   `json:"ssl"`
 	Connected bool   `json:"connected"`
-}
 
-// ParseNetData parses a NetData object from a generic object
-func ParseNetData(obj interface{}) (msg NetData) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Name, _ = mp["name"].(string)
-	msg.User, _ = mp["user"].(string)
-	msg.Realname, _ = mp["realname"].(string)
-	msg.Nick, _ = mp["nick"].(string)
-	msg.IP, _ = mp["ip"].(string)
-	port, _ := mp["port"].(json.Number)
-	portuint64, _ := strconv.ParseUint(string(port), 10, 16)
-	msg.Port = uint16(portuint64)
-	msg.SSL, _ = mp["ssl"].(bool)
-	msg.Connected, _ = mp["connected"].(bool)
-	return
+	// Capabilities contains the names of the enabled IRCv3 capabilities
+	Capabilities []string `json:"capabilities,omitempty"`
+	// SASL is the name of the SASL mechanism used to authenticate
+	SASL string `json:"sasl,omitempty"`
+	// Proxy is the type of the proxy the network connects through
+	Proxy string `json:"proxy,omitempty"`
+	// ISupport contains the features the server has advertised with RPL_ISUPPORT
+	ISupport map[string]string `json:"isupport,omitempty"`
+	// State is the connection state
+	State string `json:"state,omitempty"`
 }
 
 // ChanList contains a channel list and network name
@@ -157,22 +162,6 @@ type ChanList struct {
 	List    []string `json:"list"`
 }
 
-// ParseChanList parses a ChanList object from a generic object
-func ParseChanList(obj interface{}) (msg ChanList) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	list, _ := mp["list"].([]interface{})
-	msg.List = make([]string, len(list))
-	for i, lo := range list {
-		msg.List[i], _ = lo.(string)
-	}
-	return
-}
-
 // Invite an user to a channel
 type Invite struct {
 	Network string `json:"network"`
@@ -180,35 +169,17 @@ type Invite struct {
 	Sender  string `json:"sender"`
 }
 
-// ParseInvite parses a Invite object from a generic object
-func ParseInvite(obj interface{}) (msg Invite) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Channel, _ = mp["channel"].(string)
-	msg.Sender, _ = mp["sender"].(string)
-	return
-}
-
 // ClearHistory tells the client to clear the specific channel
 type ClearHistory struct {
 	Network string `json:"network"`
 	Channel string `json:"channel"`
 }
 
-// ParseClearHistory parses a ClearHistory object from a generic object
-func ParseClearHistory(obj interface{}) (msg ClearHistory) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Channel, _ = mp["channel"].(string)
-	return
+// HistoryPage contains a page of history and the cursor of the next page.
+// NextCursor is empty if there are no more messages.
+type HistoryPage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next-cursor,omitempty"`
 }
 
 // WhoisData contains WHOIS information
@@ -227,89 +198,21 @@ type WhoisData struct {
 	Operator   bool              `json:"operator"`
 }
 
-// ParseWhoisData parses a WhoisData object from a generic object
-func ParseWhoisData(obj interface{}) (msg WhoisData) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	chlist, _ := mp["channels"].(map[string]interface{})
-	msg.Channels = make(map[string]string)
-	for ch, role := range chlist {
-		msg.Channels[ch], _ = role.(string)
-	}
-
-	msg.Nick, _ = mp["nick"].(string)
-	msg.User, _ = mp["user"].(string)
-	msg.Host, _ = mp["host"].(string)
-	msg.RealName, _ = mp["realname"].(string)
-	msg.Away, _ = mp["away"].(string)
-	msg.Server, _ = mp["server"].(string)
-	msg.ServerInfo, _ = mp["server-info"].(string)
-	idle, _ := mp["idle"].(json.Number)
-	msg.IdleTime, _ = strconv.ParseInt(string(idle), 10, 64)
-	msg.SecureConn, _ = mp["secure-connection"].(bool)
-	msg.Operator, _ = mp["operator"].(bool)
-	return
-}
-
 // DeleteMessage contains information about which message to delete
 type DeleteMessage int64
 
-// ParseDeleteMessage parses a DeleteMessage object from a generic object
-func ParseDeleteMessage(obj interface{}) (msg DeleteMessage) {
-	switch v := obj.(type) {
-	case json.Number:
-		i, _ := strconv.ParseInt(string(v), 10, 64)
-		return DeleteMessage(i)
-	case int:
-		return DeleteMessage(v)
-	case int64:
-		return DeleteMessage(v)
-	default:
-		return 0
-	}
-}
-
 // OpenCloseChannel contains information about which channel to close
 type OpenCloseChannel struct {
 	Network string `json:"network"`
 	Channel string `json:"channel"`
 }
 
-// ParseOpenCloseChannel parses a OpenCloseChannel object from a generic object
-func ParseOpenCloseChannel(obj interface{}) (msg OpenCloseChannel) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Channel, _ = mp["channel"].(string)
-	return
-}
-
 // Kick contains information about who to kick
 type Kick struct {
 	Network string `json:"network"`
 	Channel string `json:"channel"`
 	User    string `json:"user"`
-	Message string `json:"message"`
-}
-
-// ParseKick parses a Kick object from a generic object
-func ParseKick(obj interface{}) (msg Kick) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Channel, _ = mp["channel"].(string)
-	msg.User, _ = mp["users"].(string)
-	msg.Message, _ = mp["message"].(string)
-	return
+	Message string `json:"message,omitempty"`
 }
 
 // Mode contains information about who to kick
@@ -317,21 +220,7 @@ type Mode struct {
 	Network string `json:"network"`
 	Channel string `json:"channel"`
 	Message string `json:"message"`
-	Args    string `json:"args"`
-}
-
-// ParseMode parses a Mode object from a generic object
-func ParseMode(obj interface{}) (msg Mode) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Channel, _ = mp["channel"].(string)
-	msg.Message, _ = mp["message"].(string)
-	msg.Args, _ = mp["args"].(string)
-	return
+	Args    string `json:"args,omitempty"`
 }
 
 // ModelistEntry contains a mode and a target
@@ -340,59 +229,97 @@ type ModelistEntry struct {
 	Target string `json:"target"`
 }
 
-// ParseModelistEntry parses a ModelistEntry object from a generic object
-func ParseModelistEntry(obj interface{}) (msg ModelistEntry) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	mode, _ := mp["mode"].(string)
-	if len(mode) > 0 {
-		msg.Mode = rune(mode[0])
-	}
-
-	msg.Target, _ = mp["target"].(string)
-	return
-}
-
 // ChanData contains channel information
 type ChanData struct {
-	Network    string          `json:"network"`
-	Name       string          `json:"name"`
-	Userlist   []string        `json:"userlist"`
-	Topic      string          `json:"topic"`
-	TopicSetBy string          `json:"topicsetby"`
+	Network string `json:"network"`
+	Name    string `json:"name"`
+	// Userlist contains the nicks in the channel with their highest prefixes
+	Userlist []string `json:"userlist"`
+	// Members contains the details of the users in the channel
+	Members    []Member `json:"members"`
+	Topic      string   `json:"topic"`
+	TopicSetBy string   `json:"topicsetby"`
+	// TopicSetAt is the unix time when the topic was set in milliseconds
 	TopicSetAt int64           `json:"topicsetat"`
 	Modelist   []ModelistEntry `json:"modes"`
 }
 
-// ParseChanData parses a ChanData object from a generic object
-func ParseChanData(obj interface{}) (msg ChanData) {
-	mp, ok := obj.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	msg.Network, _ = mp["network"].(string)
-	msg.Name, _ = mp["name"].(string)
-
-	list, _ := mp["userlist"].([]interface{})
-	msg.Userlist = make([]string, len(list))
-	for i, lo := range list {
-		msg.Userlist[i], _ = lo.(string)
-	}
-
-	msg.Topic, _ = mp["topic"].(string)
-	msg.TopicSetBy, _ = mp["topicsetby"].(string)
-	topicsetat, _ := mp["topicsetat"].(json.Number)
-	msg.TopicSetAt, _ = strconv.ParseInt(string(topicsetat), 10, 64)
-
-	ml, _ := mp["modes"].([]interface{})
-	msg.Modelist = make([]ModelistEntry, len(ml))
-	for i, mod := range ml {
-		msg.Modelist[i] = ParseModelistEntry(mod)
-	}
-
-	return
+// Member contains the details of a user in a channel
+type Member struct {
+	Nick string `json:"nick"`
+	// Prefixes contains the prefixes of all the channel modes the user has, from the highest to the lowest
+	Prefixes string `json:"prefixes,omitempty"`
+	User     string `json:"user,omitempty"`
+	Host     string `json:"host,omitempty"`
+	// Account is the name of the services account the user has logged in to
+	Account string `json:"account,omitempty"`
+	// Away is the away message of the user, or empty if the user isn't away
+	Away     string `json:"away,omitempty"`
+	RealName string `json:"realname,omitempty"`
+}
+
+// ReadMarker contains the ID of the last message the user has read in a channel
+type ReadMarker struct {
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+	ID      int64  `json:"id"`
+}
+
+// ChannelUnread contains the read marker and the number of unread messages in a channel
+type ChannelUnread struct {
+	Channel    string `json:"channel"`
+	ReadMarker int64  `json:"readmarker"`
+	Unread     int    `json:"unread"`
+	Highlights int    `json:"highlights"`
+}
+
+// UnreadData contains the read markers and unread counts of the channels in a network
+type UnreadData struct {
+	Network  string          `json:"network"`
+	Channels []ChannelUnread `json:"channels"`
+}
+
+// Network event types
+const (
+	NetEventSASLFailed = "saslfailed"
+	NetEventTLSFailed  = "tlsfailed"
+	NetEventState      = "state"
+)
+
+// Connection states
+const (
+	StateConnecting   = "connecting"
+	StateRegistered   = "registered"
+	StateBackoff      = "backoff"
+	StateFailed       = "failed"
+	StateDisconnected = "disconnected"
+)
+
+// NetEvent is something that happened to the connection of a network
+type NetEvent struct {
+	Network string `json:"network"`
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+	// Certificate is the certificate of the server if the TLS verification failed
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+
+	// State is the new connection state in state events
+	State string `json:"state,omitempty"`
+	// Server is the address of the server the state is about
+	Server string `json:"server,omitempty"`
+	// Attempt is the number of failed connection attempts in a row
+	Attempt int `json:"attempt,omitempty"`
+	// NextAttempt is the unix time of the next connection attempt in milliseconds when backing off
+	NextAttempt int64 `json:"next-attempt,omitempty"`
+}
+
+// CertificateInfo contains the details of a TLS certificate
+type CertificateInfo struct {
+	Subject   string   `json:"subject"`
+	Issuer    string   `json:"issuer"`
+	DNSNames  []string `json:"dns-names,omitempty"`
+	NotBefore int64    `json:"not-before"`
+	NotAfter  int64    `json:"not-after"`
+	SHA256    string   `json:"sha256"`
+	SHA512    string   `json:"sha512"`
 }
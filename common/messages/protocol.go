@@ -0,0 +1,216 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package messages contains mauIRC client <-> server messages
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Protocol versions
+const (
+	// ProtocolVersion is the current version of the websocket protocol
+	ProtocolVersion = 3
+	// MinProtocolVersion is the oldest protocol version the server still speaks
+	MinProtocolVersion = 1
+	// DefaultProtocolVersion is used for clients that don't say which version they speak
+	DefaultProtocolVersion = 2
+	// ProtocolMilliseconds is the first protocol version where the timestamps of
+	// messages, topics and reconnection attempts are in milliseconds instead of seconds
+	ProtocolMilliseconds = 3
+	// ProtocolHistoryPage is the first protocol version where the HTTP history
+	// response is a HistoryPage instead of a plain list of messages
+	ProtocolHistoryPage = 3
+)
+
+// SupportedProtocol checks if the server speaks the given protocol version
+func SupportedProtocol(version int) bool {
+	return version >= MinProtocolVersion && version <= ProtocolVersion
+}
+
+// ParseProtocol parses the protocol version a client gave in a request
+// parameter. An empty value means the default version.
+func ParseProtocol(value string) (int, bool) {
+	if len(value) == 0 {
+		return DefaultProtocolVersion, true
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || !SupportedProtocol(version) {
+		return 0, false
+	}
+	return version, true
+}
+
+// ClientTimestamp converts a timestamp a client sent in the given protocol version into milliseconds
+func ClientTimestamp(ts int64, version int) int64 {
+	if version < ProtocolMilliseconds {
+		return ts * 1000
+	}
+	return ts
+}
+
+// Versioned is implemented by objects whose format depends on the protocol version
+type Versioned interface {
+	// ForProtocol gets the object in the format of the given protocol version
+	ForProtocol(version int) interface{}
+}
+
+// ForProtocol converts the object of the container into the format of the given protocol version
+func (container Container) ForProtocol(version int) Container {
+	if versioned, ok := container.Object.(Versioned); ok {
+		container.Object = versioned.ForProtocol(version)
+	}
+	return container
+}
+
+// Hello is sent by the server when a socket is opened. The client may reply
+// with the protocol version it speaks, and the server closes the socket if it
+// doesn't support that version.
+type Hello struct {
+	Protocol    int `json:"protocol"`
+	MinProtocol int `json:"minprotocol,omitempty"`
+}
+
+// UnmarshalJSON decodes the container and leaves the object as a
+// json.RawMessage to be decoded with Decode once the type is known.
+func (container *Container) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+		Seq    uint64          `json:"seq"`
+		ID     string          `json:"id"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	container.Type = raw.Type
+	container.Object = raw.Object
+	container.Seq = raw.Seq
+	container.ID = raw.ID
+	return nil
+}
+
+// Decode decodes the object of the container into the given pointer
+func (container Container) Decode(into interface{}) error {
+	switch obj := container.Object.(type) {
+	case json.RawMessage:
+		if len(obj) == 0 {
+			return fmt.Errorf("Message of type %s has no object", container.Type)
+		}
+		return json.Unmarshal(obj, into)
+	case nil:
+		return fmt.Errorf("Message of type %s has no object", container.Type)
+	default:
+		// The container was created in the server, so round-trip the object through JSON
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, into)
+	}
+}
+
+// ForProtocol converts the timestamp into seconds for old protocol versions
+func (msg Message) ForProtocol(version int) interface{} {
+	if version < ProtocolMilliseconds {
+		msg.Timestamp /= 1000
+	}
+	return msg
+}
+
+// ForProtocol converts the topic time into seconds for old protocol versions
+func (data ChanData) ForProtocol(version int) interface{} {
+	if version < ProtocolMilliseconds {
+		data.TopicSetAt /= 1000
+	}
+	return data
+}
+
+// ForProtocol converts the time of the next attempt into seconds for old protocol versions
+func (evt NetEvent) ForProtocol(version int) interface{} {
+	if version < ProtocolMilliseconds {
+		evt.NextAttempt /= 1000
+	}
+	return evt
+}
+
+// ForProtocol converts the timestamps of the query and the results into seconds for old protocol versions
+func (results SearchResults) ForProtocol(version int) interface{} {
+	if version >= ProtocolMilliseconds {
+		return results
+	}
+	results.Query.Since /= 1000
+	results.Query.Until /= 1000
+	var converted = make([]SearchResult, len(results.Results))
+	for i, result := range results.Results {
+		result.Message = result.Message.ForProtocol(version).(Message)
+		result.Before = MessagesForProtocol(result.Before, version)
+		result.After = MessagesForProtocol(result.After, version)
+		converted[i] = result
+	}
+	results.Results = converted
+	return results
+}
+
+// MessagesForProtocol converts a list of messages into the format of the given protocol version
+func MessagesForProtocol(msgs []Message, version int) []Message {
+	if version >= ProtocolMilliseconds || msgs == nil {
+		return msgs
+	}
+	var converted = make([]Message, len(msgs))
+	for i, msg := range msgs {
+		converted[i] = msg.ForProtocol(version).(Message)
+	}
+	return converted
+}
+
+// TypeInfo contains the object types of a message type. Inbound is the object
+// clients send and Outbound is the object the server sends. Either is nil if
+// the message is never sent in that direction.
+type TypeInfo struct {
+	Inbound  interface{}
+	Outbound interface{}
+}
+
+// Types contains the object types of all message types
+var Types = map[string]TypeInfo{
+	MsgRaw:        {Inbound: RawMessage{}, Outbound: RawMessage{}},
+	MsgInvite:     {Outbound: Invite{}},
+	MsgNickChange: {Outbound: NickChange{}},
+	MsgNetData:    {Outbound: NetData{}},
+	MsgChanData:   {Outbound: ChanData{}},
+	MsgWhois:      {Outbound: WhoisData{}},
+	MsgClear:      {Inbound: ClearHistory{}, Outbound: ClearHistory{}},
+	MsgDelete:     {Inbound: DeleteMessage(0), Outbound: DeleteMessage(0)},
+	MsgChanList:   {Outbound: ChanList{}},
+	MsgMessage:    {Inbound: Message{}, Outbound: Message{}},
+	MsgKick:       {Inbound: Kick{}},
+	MsgMode:       {Inbound: Mode{}},
+	MsgClose:      {Inbound: OpenCloseChannel{}},
+	MsgOpen:       {Inbound: OpenCloseChannel{}},
+	MsgSearch:     {Inbound: Search{}, Outbound: SearchResults{}},
+	MsgReadMarker: {Inbound: ReadMarker{}, Outbound: ReadMarker{}},
+	MsgUnread:     {Outbound: UnreadData{}},
+	MsgResume:     {Outbound: Resume{}},
+	MsgResponse:   {Outbound: Response{}},
+	MsgError:      {Outbound: ErrorResponse{}},
+	MsgHello:      {Inbound: Hello{}, Outbound: Hello{}},
+	MsgNetEvent:   {Outbound: NetEvent{}},
+}
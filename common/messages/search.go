@@ -0,0 +1,46 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package messages contains mauIRC client <-> server messages
+package messages
+
+// Search contains a full-text history search query and its filters.
+// Context is the number of messages to include before and after each match.
+// Zero means the server default and a negative number means no context.
+type Search struct {
+	Query   string `json:"query"`
+	Network string `json:"network,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Sender  string `json:"sender,omitempty"`
+	Since   int64  `json:"since,omitempty"`
+	Until   int64  `json:"until,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+	Context int    `json:"context,omitempty"`
+}
+
+// SearchResult is a single search match and the messages around it
+type SearchResult struct {
+	Message Message   `json:"message"`
+	Rank    float64   `json:"rank"`
+	Before  []Message `json:"before,omitempty"`
+	After   []Message `json:"after,omitempty"`
+}
+
+// SearchResults contains the results of a search, best match first
+type SearchResults struct {
+	Query   Search         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
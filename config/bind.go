@@ -0,0 +1,129 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"maunium.net/go/mauirc-server/util/proxy"
+)
+
+// dialTimeout is the timeout for opening the TCP connection to an IRC server
+const dialTimeout = 30 * time.Second
+
+// GetBind gets the local address or hostname the network connects from. The
+// bind address of the network overrides the one of the user.
+func (net *netImpl) GetBind() string {
+	if len(net.Bind) > 0 {
+		return net.Bind
+	}
+	return net.Owner.GetBind()
+}
+
+// dialer creates the dialer for connecting to the given server from the bind
+// address and through the proxy of the network. Errors caused by settings
+// that can't be used are fatal. If the bind address can't be resolved, the
+// connection attempt fails instead of connecting from the default address.
+func (net *netImpl) dialer(addr connAddress) (proxy.Dialer, error) {
+	bind := net.GetBind()
+	proxyConf := net.GetProxy()
+	if proxyConf == nil && proxy.IsOnion(addr.IP) {
+		return nil, fatalError{fmt.Errorf("%s is an onion service, which can only be reached through Tor", addr.IP)}
+	}
+
+	// When using a proxy, the bind address is used for the connection to the proxy
+	target := addr.IP
+	if proxyConf != nil {
+		target = net.proxyConfig().Host()
+	}
+	dialer, err := bindDialer(bind, target)
+	if err != nil {
+		// Connecting from another address could reveal the address the user wants to hide
+		return nil, fmt.Errorf("Failed to resolve bind address %s: %s", bind, err)
+	}
+
+	if proxyConf == nil {
+		return dialer, nil
+	}
+	proxyDialer, err := proxy.New(net.proxyConfig(), dialer)
+	if err != nil {
+		return nil, fatalError{err}
+	}
+	return proxyDialer, nil
+}
+
+// bindDialer creates a dialer that connects from the given local address or
+// hostname. Go dials hostnames that have both IPv4 and IPv6 addresses with
+// Happy Eyeballs, and only tries the addresses that match the family of the
+// local address. An empty bind address creates a dialer that lets the OS pick
+// the local address.
+func bindDialer(bind, server string) (*net.Dialer, error) {
+	if len(bind) == 0 {
+		return &net.Dialer{Timeout: dialTimeout}, nil
+	}
+	localIP := net.ParseIP(bind)
+	if localIP == nil {
+		ips, err := net.LookupIP(bind)
+		if err != nil {
+			return nil, err
+		} else if len(ips) == 0 {
+			return nil, fmt.Errorf("No addresses found")
+		}
+		localIP = preferredIP(ips, server)
+	}
+	return &net.Dialer{Timeout: dialTimeout, LocalAddr: &net.TCPAddr{IP: localIP}}, nil
+}
+
+// preferredIP picks the local address to bind to from the addresses of a
+// vhost. A dialer with a local address only tries the server addresses of the
+// same family, so the server is resolved to find the families it can be
+// reached with. IPv6 is preferred if both ends have it.
+func preferredIP(ips []net.IP, server string) net.IP {
+	serverIPs := []net.IP{net.ParseIP(server)}
+	if serverIPs[0] == nil {
+		var err error
+		serverIPs, err = net.LookupIP(server)
+		if err != nil {
+			// Dialing will fail anyway and report the error
+			return ips[0]
+		}
+	}
+	var hasIPv4, hasIPv6 bool
+	for _, ip := range serverIPs {
+		if ip.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+
+	var ipv4 net.IP
+	for _, ip := range ips {
+		if ip.To4() == nil && hasIPv6 {
+			return ip
+		} else if ip.To4() != nil && hasIPv4 && ipv4 == nil {
+			ipv4 = ip
+		}
+	}
+	if ipv4 != nil {
+		return ipv4
+	}
+	return ips[0]
+}
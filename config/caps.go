@@ -0,0 +1,45 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	msg "github.com/sorcix/irc"
+	irc "maunium.net/go/libmauirc"
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+// registrationAuth starts the capability negotiation and sends the server
+// password. libmauirc runs it before sending NICK and USER, so the server
+// suspends the registration until the negotiation has ended.
+type registrationAuth struct {
+	net *netImpl
+}
+
+func (auth *registrationAuth) Do(c irc.Connection) {
+	auth.net.SASLSession = nil
+	auth.net.SASLStatus = saslPending
+	auth.net.Caps.Start()
+	if len(auth.net.Password) > 0 {
+		c.Send(&msg.Message{Command: msg.PASS, Params: []string{auth.net.Password}})
+	}
+}
+
+// capsChanged sends the network data to the clients after capabilities have been enabled or disabled
+func (net *netImpl) capsChanged() {
+	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetData, Object: net.GetNetData()})
+}
@@ -18,137 +18,269 @@
 package config
 
 import (
+	"strings"
+
 	msg "github.com/sorcix/irc"
+	"maunium.net/go/mauirc-server/common/errors"
 	"maunium.net/go/mauirc-server/common/messages"
 	"maunium.net/go/mauirc-server/database"
+	"maunium.net/go/mauirc-server/interfaces"
 )
 
-// HandleCommand handles mauIRC commands from clients
-func (user *userImpl) HandleCommand(data messages.Container) {
+// HandleCommand handles mauIRC commands from clients. Replies meant only for
+// the client that sent the command are passed to the reply function. If the
+// command has an ID, a response or an error with the same ID is always replied.
+// Timestamps in the commands are in the format of the given protocol version.
+func (user *userImpl) HandleCommand(data messages.Container, protocol int, reply func(messages.Container)) {
+	var result interface{}
+	var err error
 	switch data.Type {
 	case messages.MsgRaw:
-		user.rawMessage(messages.ParseRawMessage(data.Object))
+		var obj messages.RawMessage
+		if err = decode(data, &obj); err == nil {
+			err = user.rawMessage(obj)
+		}
 	case messages.MsgMessage:
-		user.cmdMessage(messages.ParseMessage(data.Object))
+		var obj messages.Message
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdMessage(obj)
+		}
 	case messages.MsgKick:
-		user.cmdKick(messages.ParseKick(data.Object))
+		var obj messages.Kick
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdKick(obj)
+		}
 	case messages.MsgMode:
-		user.cmdMode(messages.ParseMode(data.Object))
+		var obj messages.Mode
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdMode(obj)
+		}
 	case messages.MsgClear:
-		user.cmdClearHistory(messages.ParseClearHistory(data.Object))
+		var obj messages.ClearHistory
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdClearHistory(obj)
+		}
 	case messages.MsgClose:
-		user.cmdCloseChannel(messages.ParseOpenCloseChannel(data.Object))
+		var obj messages.OpenCloseChannel
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdCloseChannel(obj)
+		}
 	case messages.MsgOpen:
-		user.cmdOpenChannel(messages.ParseOpenCloseChannel(data.Object))
+		var obj messages.OpenCloseChannel
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdOpenChannel(obj)
+		}
 	case messages.MsgDelete:
-		user.cmdDeleteMessage(messages.ParseDeleteMessage(data.Object))
+		var obj messages.DeleteMessage
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdDeleteMessage(obj)
+		}
+	case messages.MsgSearch:
+		var obj messages.Search
+		if err = decode(data, &obj); err == nil {
+			result, err = user.cmdSearch(obj, protocol, reply)
+		}
+	case messages.MsgReadMarker:
+		var obj messages.ReadMarker
+		if err = decode(data, &obj); err == nil {
+			err = user.cmdReadMarker(obj)
+		}
+	default:
+		err = errors.UnknownCommand
+	}
+
+	if err != nil {
+		webErr, ok := err.(errors.WebError)
+		if !ok {
+			webErr = errors.Internal
+		}
+		if len(data.ID) > 0 {
+			reply(messages.Container{Type: messages.MsgError, ID: data.ID, Object: messages.ErrorResponse{Command: data.Type, WebError: webErr}})
+		}
+	} else if len(data.ID) > 0 {
+		reply(messages.Container{Type: messages.MsgResponse, ID: data.ID, Object: messages.Response{Command: data.Type, Result: result}})
 	}
 }
 
-func (user *userImpl) rawMessage(data messages.RawMessage) {
-	if len(data.Network) == 0 || len(data.Message) == 0 {
-		return
+// decode decodes the object of the given command
+func decode(data messages.Container, into interface{}) error {
+	err := data.Decode(into)
+	if err != nil {
+		return errors.InvalidBodyFormat.WithExtraInfo(err.Error())
 	}
+	return nil
+}
 
-	net := user.GetNetwork(data.Network)
+// getConnectedNetwork gets the network with the given name and makes sure it's connected
+func (user *userImpl) getConnectedNetwork(name string) (interfaces.Network, error) {
+	net := user.GetNetwork(name)
 	if net == nil {
-		return
+		return nil, errors.NetworkNotFound
+	} else if !net.IsConnected() {
+		return nil, errors.NotConnected
+	}
+	return net, nil
+}
+
+func (user *userImpl) rawMessage(data messages.RawMessage) error {
+	if len(data.Network) == 0 || len(data.Message) == 0 {
+		return errors.MissingFields
+	}
+
+	net, err := user.getConnectedNetwork(data.Network)
+	if err != nil {
+		return err
 	}
 
 	net.Tunnel().Send(msg.ParseMessage(data.Message))
+	return nil
 }
 
-func (user *userImpl) cmdDeleteMessage(data messages.DeleteMessage) {
+func (user *userImpl) cmdDeleteMessage(data messages.DeleteMessage) error {
 	id := int64(data)
+	if id <= 0 {
+		return errors.MissingFields
+	}
+
 	err := database.DeleteMessage(user.Email, id)
 	if err != nil {
 		log.Warnf("<%s> Failed to delete message #%d: %s\n", user.Email, id, err)
-		return
+		return errors.Internal
 	}
 
 	user.SendMessage(messages.Container{Type: messages.MsgDelete, Object: data})
+	return nil
+}
+
+func (user *userImpl) cmdSearch(data messages.Search, protocol int, reply func(messages.Container)) (interface{}, error) {
+	if len(data.Query) == 0 {
+		return nil, errors.MissingFields
+	}
+	data.Since = messages.ClientTimestamp(data.Since, protocol)
+	data.Until = messages.ClientTimestamp(data.Until, protocol)
+
+	results, err := database.Search(user.Email, data)
+	if err != nil {
+		log.Warnf("<%s> Failed to search for \"%s\": %s\n", user.Email, data.Query, err)
+		return nil, errors.Internal
+	}
+
+	reply(messages.Container{Type: messages.MsgSearch, Object: messages.SearchResults{Query: data, Results: results}})
+	return nil, nil
+}
+
+func (user *userImpl) cmdReadMarker(data messages.ReadMarker) error {
+	if len(data.Network) == 0 || len(data.Channel) == 0 {
+		return errors.MissingFields
+	} else if data.ID < 0 {
+		return errors.FieldFormatting
+	}
+
+	err := database.SetReadMarker(user.Email, data)
+	if err != nil {
+		log.Warnf("<%s> Failed to set read marker of %s@%s: %s\n", user.Email, data.Channel, data.Network, err)
+		return errors.Internal
+	}
+
+	user.SendMessage(messages.Container{Type: messages.MsgReadMarker, Object: data})
+	return nil
 }
 
-func (user *userImpl) cmdClearHistory(data messages.ClearHistory) {
+func (user *userImpl) cmdClearHistory(data messages.ClearHistory) error {
 	if len(data.Network) == 0 || len(data.Channel) == 0 {
-		return
+		return errors.MissingFields
 	}
 
 	err := database.ClearChannel(user.GetEmail(), data.Network, data.Channel)
 	if err != nil {
 		log.Warnf("<%s> Failed to clear history of %s@%s: %s", user.GetEmail(), data.Network, data.Channel, err)
-		return
+		return errors.Internal
 	}
 
 	user.SendMessage(messages.Container{Type: messages.MsgClear, Object: data})
+	return nil
 }
 
-func (user *userImpl) cmdCloseChannel(data messages.OpenCloseChannel) {
+func (user *userImpl) cmdCloseChannel(data messages.OpenCloseChannel) error {
 	if len(data.Network) == 0 || len(data.Channel) == 0 {
-		return
+		return errors.MissingFields
 	}
 
 	network := user.GetNetwork(data.Network)
 	if network == nil {
-		return
+		return errors.NetworkNotFound
+	} else if !network.GetActiveChannels().Has(data.Channel) {
+		return errors.ChannelNotFound
 	}
 
 	network.GetActiveChannels().Remove(data.Channel)
+	return nil
 }
 
-func (user *userImpl) cmdOpenChannel(data messages.OpenCloseChannel) {
+func (user *userImpl) cmdOpenChannel(data messages.OpenCloseChannel) error {
 	if len(data.Network) == 0 || len(data.Channel) == 0 {
-		return
+		return errors.MissingFields
 	}
 
 	network := user.GetNetwork(data.Network)
 	if network == nil {
-		return
+		return errors.NetworkNotFound
 	}
 
 	network.GetActiveChannels().Put(&chanDataImpl{Network: network.GetName(), Name: data.Channel})
+	return nil
 }
 
-func (user *userImpl) cmdMessage(data messages.Message) {
+func (user *userImpl) cmdMessage(data messages.Message) error {
 	if len(data.Network) == 0 || len(data.Channel) == 0 || len(data.Command) == 0 || len(data.Message) == 0 {
-		return
+		return errors.MissingFields
 	}
 
-	net := user.GetNetwork(data.Network)
-	if net == nil {
-		return
+	net, err := user.getConnectedNetwork(data.Network)
+	if err != nil {
+		return err
 	}
 
-	if len(data.Channel) == 0 || len(data.Command) == 0 || len(data.Message) == 0 {
-		return
-	}
 	net.SendMessage(data.Channel, data.Command, data.Message)
+	return nil
 }
 
-func (user *userImpl) cmdKick(data messages.Kick) {
+func (user *userImpl) cmdKick(data messages.Kick) error {
 	if len(data.Network) == 0 || len(data.Channel) == 0 || len(data.User) == 0 {
-		return
+		return errors.MissingFields
 	} else if len(data.Message) == 0 {
 		data.Message = "Bye bye"
 	}
 
-	net := user.GetNetwork(data.Network)
-	if net == nil {
-		return
+	net, err := user.getConnectedNetwork(data.Network)
+	if err != nil {
+		return err
+	} else if !net.GetActiveChannels().Has(data.Channel) {
+		return errors.ChannelNotFound
 	}
 
 	net.Tunnel().Kick(data.Channel, data.User, data.Message)
+	return nil
 }
 
-func (user *userImpl) cmdMode(data messages.Mode) {
+func (user *userImpl) cmdMode(data messages.Mode) error {
 	if len(data.Network) == 0 || len(data.Channel) == 0 || len(data.Message) == 0 {
-		return
+		return errors.MissingFields
 	}
 
-	net := user.GetNetwork(data.Network)
-	if net == nil {
-		return
+	net, err := user.getConnectedNetwork(data.Network)
+	if err != nil {
+		return err
 	}
 
-	net.Tunnel().Mode(data.Channel, data.Message, data.Args)
+	support := net.GetSupport()
+	if !support.IsChannel(data.Channel) {
+		net.Tunnel().Mode(data.Channel, data.Message, data.Args)
+		return nil
+	}
+	// Servers ignore the parameter modes after their MODES limit, so send them in multiple commands
+	for _, change := range support.SplitModes(data.Message, strings.Fields(data.Args)) {
+		net.Tunnel().Mode(data.Channel, change.Modes, strings.Join(change.Args, " "))
+	}
+	return nil
 }
@@ -22,21 +22,25 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
-	"maunium.net/go/mauirc-server/common/messages"
 	"maunium.net/go/mauirc-server/config/mail"
 	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/hub"
 	"maunium.net/go/maulogger"
 )
 
-// MessageBufferSize is a magical value
+// MessageBufferSize is the default number of messages buffered for each connected client
 const MessageBufferSize = 128
 
+// ReplayBufferSize is the default number of messages kept in memory for resuming sessions
+const ReplayBufferSize = 1024
+
 var log = maulogger.CreateSublogger("Net", maulogger.LevelInfo)
 
 // NewConfig creates a new Configuration instance
@@ -46,27 +50,39 @@ func NewConfig(path string) interfaces.Configuration {
 }
 
 type configImpl struct {
-	Path             string               `yaml:"-" json:"-"`
-	SQL              mysqlImpl            `yaml:"sql" json:"sql"`
-	Users            userListImpl         `yaml:"users" json:"users"`
-	Mail             mail.Config          `yaml:"mail" json:"mail"`
-	IP               string               `yaml:"ip" json:"ip"`
-	Port             int                  `yaml:"port" json:"port"`
-	TrustHeadersF    bool                 `yaml:"trust-headers" json:"trust-headers"`
-	AutosaveConfig   bool                 `yaml:"save-config-on-edit" json:"save-config-on-edit"`
-	Address          string               `yaml:"external-address" json:"external-address"`
-	CSecretB64       string               `yaml:"cookie-secret" json:"cookie-secret"`
-	HTTPSOnlyCookies bool                 `yaml:"https-only" json:"https-only"`
-	Ident            interfaces.IdentConf `yaml:"ident" json:"ident"`
-	CookieSecret     []byte               `yaml:"-" json:"-"`
+	Path             string                `yaml:"-" json:"-"`
+	SQL              sqlImpl               `yaml:"sql" json:"sql"`
+	Users            userListImpl          `yaml:"users" json:"users"`
+	Mail             mail.Config           `yaml:"mail" json:"mail"`
+	IP               string                `yaml:"ip" json:"ip"`
+	Port             int                   `yaml:"port" json:"port"`
+	TrustHeadersF    bool                  `yaml:"trust-headers" json:"trust-headers"`
+	AutosaveConfig   bool                  `yaml:"save-config-on-edit" json:"save-config-on-edit"`
+	Address          string                `yaml:"external-address" json:"external-address"`
+	CSecretB64       string                `yaml:"cookie-secret" json:"cookie-secret"`
+	HTTPSOnlyCookies bool                  `yaml:"https-only" json:"https-only"`
+	Ident            interfaces.IdentConf  `yaml:"ident" json:"ident"`
+	Retention        *interfaces.Retention `yaml:"retention,omitempty" json:"retention,omitempty"`
+	PruneInterval    int                   `yaml:"prune-interval,omitempty" json:"prune-interval,omitempty"`
+	ClientBuffer     int                   `yaml:"client-buffer-size,omitempty" json:"client-buffer-size,omitempty"`
+	SlowClients      string                `yaml:"slow-client-policy,omitempty" json:"slow-client-policy,omitempty"`
+	ReplayBuffer     int                   `yaml:"replay-buffer-size,omitempty" json:"replay-buffer-size,omitempty"`
+	MetricsAddr      string                `yaml:"metrics-address,omitempty" json:"metrics-address,omitempty"`
+	CookieSecret     []byte                `yaml:"-" json:"-"`
 }
 
-type mysqlImpl struct {
+// DefaultSQLiteFile is the name of the SQLite database in the config directory if sql.file isn't set
+const DefaultSQLiteFile = "mauirc.db"
+
+type sqlImpl struct {
+	Type     string `yaml:"type" json:"type"`
 	IP       string `yaml:"ip" json:"ip"`
 	Port     int    `yaml:"port" json:"port"`
 	Username string `yaml:"username" json:"username"`
 	Password string `yaml:"password" json:"password"`
 	Database string `yaml:"database" json:"database"`
+	SSLMode  string `yaml:"sslmode,omitempty" json:"sslmode,omitempty"`
+	File     string `yaml:"file,omitempty" json:"file,omitempty"`
 }
 
 type userListImpl []*userImpl
@@ -117,9 +133,23 @@ func (config *configImpl) Load() error {
 func (config *configImpl) Connect() {
 	for _, user := range config.Users {
 		user.LoadGlobalScripts(config.Path)
-		user.NewMessages = make(chan messages.Container, MessageBufferSize)
+		user.Hub = config.newHub()
 		user.InitNetworks()
 	}
+	go config.pruneLoop()
+}
+
+// newHub creates a message hub for an user
+func (config *configImpl) newHub() *hub.Hub {
+	size := config.ClientBuffer
+	if size <= 0 {
+		size = MessageBufferSize
+	}
+	replay := config.ReplayBuffer
+	if replay <= 0 {
+		replay = ReplayBufferSize
+	}
+	return hub.New(size, replay, hub.ParsePolicy(config.SlowClients))
 }
 
 // Save the configuration file
@@ -197,9 +227,9 @@ func (config *configImpl) CreateUser(email, password string) (user interfaces.Us
 		}
 	}
 	userInt := &userImpl{
-		HostConf:    config,
-		NewMessages: make(chan messages.Container, MessageBufferSize),
-		Email:       email,
+		HostConf: config,
+		Hub:      config.newHub(),
+		Email:    email,
 	}
 
 	if config.Mail.Enabled {
@@ -216,20 +246,60 @@ func (config *configImpl) CreateUser(email, password string) (user interfaces.Us
 	return user, token, timed
 }
 
+func (config *configImpl) GetSQLType() string {
+	switch strings.ToLower(config.SQL.Type) {
+	case "", "mysql":
+		return "mysql"
+	case "postgres", "postgresql":
+		return "postgres"
+	case "sqlite", "sqlite3":
+		return "sqlite"
+	default:
+		return config.SQL.Type
+	}
+}
+
 func (config *configImpl) GetSQLString() string {
-	return fmt.Sprintf("%[1]s:%[2]s@tcp(%[3]s:%[4]d)/%[5]s",
-		config.SQL.Username,
-		config.SQL.Password,
-		config.SQL.IP,
-		config.SQL.Port,
-		config.SQL.Database,
-	)
+	switch config.GetSQLType() {
+	case "postgres":
+		dsn := &url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(config.SQL.Username, config.SQL.Password),
+			Host:   fmt.Sprintf("%[1]s:%[2]d", config.SQL.IP, config.SQL.Port),
+			Path:   config.SQL.Database,
+		}
+		if len(config.SQL.SSLMode) > 0 {
+			dsn.RawQuery = url.Values{"sslmode": {config.SQL.SSLMode}}.Encode()
+		}
+		return dsn.String()
+	case "sqlite":
+		file := config.SQL.File
+		if len(file) == 0 {
+			file = DefaultSQLiteFile
+		}
+		if filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(config.Path, file)
+	default:
+		return fmt.Sprintf("%[1]s:%[2]s@tcp(%[3]s:%[4]d)/%[5]s",
+			config.SQL.Username,
+			config.SQL.Password,
+			config.SQL.IP,
+			config.SQL.Port,
+			config.SQL.Database,
+		)
+	}
 }
 
 func (config *configImpl) GetMail() interfaces.Mail {
 	return config.Mail
 }
 
+func (config *configImpl) GetRetention() *interfaces.Retention {
+	return config.Retention
+}
+
 func (config *configImpl) GetIDENTConfig() interfaces.IdentConf {
 	return config.Ident
 }
@@ -250,6 +320,10 @@ func (config *configImpl) GetExternalAddr() string {
 	return config.Address
 }
 
+func (config *configImpl) GetMetricsAddr() string {
+	return config.MetricsAddr
+}
+
 func (config *configImpl) GetCookieSecret() []byte {
 	return config.CookieSecret
 }
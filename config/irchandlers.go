@@ -19,13 +19,14 @@ package config
 
 import (
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	msg "github.com/sorcix/irc"
 	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/isupport"
 	"maunium.net/go/mauirc-server/util/userlist"
 )
 
@@ -33,60 +34,73 @@ func (net *netImpl) mode(evt *msg.Message) {
 	if len(evt.Host) == 0 {
 		return
 	}
-	if evt.Params[0][0] == '#' {
+	if net.Support.IsChannel(evt.Params[0]) && len(evt.Params) > 1 {
 		ci := net.ChannelInfo.get(evt.Params[0])
 		if ci == nil {
 			net.ChannelInfo.Put(&chanDataImpl{Network: net.Name, Name: evt.Params[0]})
 			ci = net.ChannelInfo.get(evt.Params[0])
 		}
 
-		var targets = evt.Params[2:]
+		var params = evt.Params[2:]
+		var rules = net.userRules()
 
 		var add = true
-		var ii = 0
 		for _, r := range evt.Params[1] {
 			if r == '-' {
 				add = false
+				continue
 			} else if r == '+' {
 				add = true
-			} else {
-				var target string
-				if len(targets) > ii {
-					target = targets[ii]
-				} else {
-					target = ""
-				}
+				continue
+			}
+
+			var param string
+			if net.Support.TakesParam(r, add) && len(params) > 0 {
+				param, params = params[0], params[1:]
+			}
 
+			switch net.Support.ModeType(r) {
+			case isupport.ModePrefix:
+				// Prefix modes are stored in the member list
+				if member := ci.Members.Find(param, rules); member != nil {
+					if add {
+						member.Prefixes = rules.AddPrefix(member.Prefixes, net.Support.PrefixOfMode(r))
+					} else {
+						member.Prefixes = rules.RemovePrefix(member.Prefixes, net.Support.PrefixOfMode(r))
+					}
+					ci.Members.Sort(rules)
+				}
+			case isupport.ModeAlways, isupport.ModeSet:
+				// These modes only have one value at a time
+				ci.ModeList = ci.ModeList.RemoveModes(r)
 				if add {
-					ci.ModeList = ci.ModeList.AddMode(r, target)
-				} else {
-					ci.ModeList = ci.ModeList.RemoveMode(r, target)
+					ci.ModeList = ci.ModeList.AddMode(r, param)
 				}
-
-				if len(target) > 0 {
-					ci.UserList.SetPrefix(target, fmt.Sprintf("%c", ci.ModeList.PrefixOf(target)))
-					sort.Sort(ci.UserList)
+			default:
+				if add {
+					ci.ModeList = ci.ModeList.AddMode(r, param)
+				} else {
+					ci.ModeList = ci.ModeList.RemoveMode(r, param)
 				}
-				ii++
 			}
 		}
 
 		net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 	}
-	net.ReceiveMessage(evt.Params[0], evt.Name, "mode", strings.Join(evt.Params[1:], " "))
+	net.receiveLine(evt, evt.Params[0], evt.Name, "mode", strings.Join(evt.Params[1:], " "))
 }
 
 func (net *netImpl) nick(evt *msg.Message) {
-	if evt.Name == net.IRC.GetNick() {
+	if net.isOwnNick(evt.Name) {
 		net.Owner.SendMessage(messages.Container{Type: messages.MsgNickChange, Object: messages.NickChange{Network: net.Name, Nick: evt.Trailing}})
 		net.Nick = evt.Trailing
 	}
-	for _, ci := range net.ChannelInfo {
-		if b, i := ci.UserList.Contains(evt.Name); b {
-			ci.UserList[i] = evt.Trailing
-			sort.Sort(ci.UserList)
+	for _, ci := range net.ChannelInfo.channels {
+		if member := ci.Members.Find(evt.Name, net.userRules()); member != nil {
+			member.Nick = evt.Trailing
+			ci.Members.Sort(net.userRules())
 
-			net.ReceiveMessage(ci.Name, evt.Name, "nick", evt.Trailing)
+			net.receiveLine(evt, ci.Name, evt.Name, "nick", evt.Trailing)
 			net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 		}
 	}
@@ -99,16 +113,11 @@ func (net *netImpl) userlist(evt *msg.Message) {
 		ci = net.ChannelInfo.get(evt.Params[2])
 	}
 
-	users := strings.Split(evt.Trailing, " ")
-	if len(users[len(users)-1]) == 0 {
-		users = users[:len(users)-1]
-	}
-
-	if ci.ReceivingUserList {
-		ci.UserList = ci.UserList.Merge(users)
-	} else {
-		ci.UserList = userlist.List(users)
-		ci.ReceivingUserList = true
+	rules := net.userRules()
+	for _, name := range strings.Split(evt.Trailing, " ") {
+		if len(name) > 0 {
+			ci.newMembers = ci.newMembers.Put(userlist.ParseName(name, rules), rules)
+		}
 	}
 }
 
@@ -119,8 +128,9 @@ func (net *netImpl) userlistend(evt *msg.Message) {
 		ci = net.ChannelInfo.get(evt.Params[1])
 	}
 
-	ci.ReceivingUserList = false
-	sort.Sort(ci.UserList)
+	ci.Members = ci.Members.Replace(ci.newMembers, net.userRules())
+	ci.newMembers = nil
+	ci.Members.Sort(net.userRules())
 	net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 }
 
@@ -140,8 +150,8 @@ func (net *netImpl) topic(evt *msg.Message) {
 	}
 	ci.Topic = evt.Trailing
 	ci.TopicSetBy = evt.Name
-	ci.TopicSetAt = time.Now().Unix()
-	net.ReceiveMessage(ci.Name, evt.Name, "topic", evt.Trailing)
+	ci.TopicSetAt = messages.UnixMilli(time.Now())
+	net.receiveLine(evt, ci.Name, evt.Name, "topic", evt.Trailing)
 	net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 }
 
@@ -162,7 +172,7 @@ func (net *netImpl) noperms(evt *msg.Message) {
 			ID:        -1,
 			Network:   net.Name,
 			Channel:   evt.Params[1],
-			Timestamp: time.Now().Unix(),
+			Timestamp: messages.UnixMilli(time.Now()),
 			Sender:    "[" + net.Name + "]",
 			Command:   "privmsg",
 			Message:   evt.Trailing,
@@ -179,55 +189,60 @@ func (net *netImpl) topicset(evt *msg.Message) {
 	}
 	ci.TopicSetBy = evt.Params[2]
 	setAt, err := strconv.ParseInt(evt.Params[3], 10, 64)
-	if err != nil {
-		ci.TopicSetAt = setAt
+	if err == nil {
+		ci.TopicSetAt = setAt * 1000
 	}
 	net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 }
 
 func (net *netImpl) quit(evt *msg.Message) {
-	for _, ci := range net.ChannelInfo {
-		if b, i := ci.UserList.Contains(evt.Name); b {
-			ci.UserList[i] = ci.UserList[len(ci.UserList)-1]
-			ci.UserList = ci.UserList[:len(ci.UserList)-1]
-			sort.Sort(ci.UserList)
+	for _, ci := range net.ChannelInfo.channels {
+		if ci.Members.Find(evt.Name, net.userRules()) != nil {
+			ci.Members = ci.Members.Remove(evt.Name, net.userRules())
 
-			net.ReceiveMessage(ci.Name, evt.Name, "quit", evt.Trailing)
+			net.receiveLine(evt, ci.Name, evt.Name, "quit", evt.Trailing)
 			net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 		}
 	}
 }
 
 func (net *netImpl) join(evt *msg.Message) {
-	net.ReceiveMessage(evt.Params[0], evt.Name, "join", evt.Trailing)
-	net.joinpart(evt.Name, evt.Params[0], false)
+	member := &messages.Member{Nick: evt.Name, User: evt.User, Host: evt.Host}
+	// With extended-join, the account name is the second parameter and the realname is the trailing
+	if len(evt.Params) > 1 {
+		if evt.Params[1] != "*" {
+			member.Account = evt.Params[1]
+		}
+		member.RealName = evt.Trailing
+	}
+	// Joins have no message, the trailing is either the channel or the realname
+	net.receiveLine(evt, evt.Params[0], evt.Name, "join", "")
+	net.joinpart(member, evt.Params[0], false)
 }
 
 func (net *netImpl) part(evt *msg.Message) {
-	net.ReceiveMessage(evt.Params[0], evt.Name, "part", evt.Trailing)
-	net.joinpart(evt.Name, evt.Params[0], true)
+	net.receiveLine(evt, evt.Params[0], evt.Name, "part", evt.Trailing)
+	net.joinpart(&messages.Member{Nick: evt.Name}, evt.Params[0], true)
 }
 
 func (net *netImpl) kick(evt *msg.Message) {
-	net.ReceiveMessage(evt.Params[0], evt.Name, "kick", evt.Params[1]+":"+evt.Trailing)
-	net.joinpart(evt.Params[1], evt.Params[0], true)
+	net.receiveLine(evt, evt.Params[0], evt.Name, "kick", evt.Params[1]+":"+evt.Trailing)
+	net.joinpart(&messages.Member{Nick: evt.Params[1]}, evt.Params[0], true)
 }
 
 func (net *netImpl) privmsg(evt *msg.Message) {
-	if evt.Params[0][0] == '@' {
-		evt.Params[0] = evt.Params[0][1:]
-	}
+	evt.Params[0] = net.Support.StripStatusPrefix(evt.Params[0])
 	if evt.IsServer() {
-		if evt.Params[0][0] != '#' {
+		if !net.Support.IsChannel(evt.Params[0]) {
 			return
 		}
 		evt.Name = fmt.Sprintf("SERVER [%s]", evt.Name)
 	}
-	net.ReceiveMessage(evt.Params[0], evt.Name, "privmsg", evt.Trailing)
+	net.receiveLine(evt, evt.Params[0], evt.Name, "privmsg", evt.Trailing)
 }
 
 func (net *netImpl) action(evt *msg.Message) {
-	net.ReceiveMessage(evt.Params[0], evt.Name, "action", evt.Trailing)
+	net.receiveLine(evt, evt.Params[0], evt.Name, "action", evt.Trailing)
 }
 
 func (net *netImpl) invite(evt *msg.Message) {
@@ -239,50 +254,59 @@ func (net *netImpl) invite(evt *msg.Message) {
 }
 
 func (net *netImpl) invited(evt *msg.Message) {
-	net.ReceiveMessage(evt.Params[2], evt.Params[0], "invited", evt.Params[1])
+	net.receiveLine(evt, evt.Params[2], evt.Params[0], "invited", evt.Params[1])
 }
 
 func (net *netImpl) inviteFail(evt *msg.Message) {
-	net.ReceiveMessage(evt.Params[2], evt.Params[0], "invitefail", evt.Params[1])
+	net.receiveLine(evt, evt.Params[2], evt.Params[0], "invitefail", evt.Params[1])
 }
 
 func (net *netImpl) connected(evt *msg.Message) {
+	net.Caps.Registered()
+	if !net.checkSASL() {
+		return
+	}
+	net.registered()
 	net.IRC.List()
-	for channel := range net.ChannelInfo {
-		if strings.HasPrefix(channel, "#") {
-			net.IRC.Join(channel, "")
+	for _, ci := range net.ChannelInfo.channels {
+		if net.Support.IsChannel(ci.Name) {
+			net.IRC.Join(ci.Name, "")
 		}
 	}
 	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetData, Object: messages.NetData{Name: net.GetName(), Connected: true}})
 }
 
 func (net *netImpl) disconnected(evt *msg.Message) {
-	log.Warnf("Disconnected from %s:%d\n", net.IP, net.Port)
+	log.Warnf("Disconnected from %s\n", hostPort(interfaces.Server{IP: net.Address.IP, Port: net.Address.Port}))
+	net.Caps.Disconnected()
+	net.SASLSession = nil
+	net.SASLStatus = saslPending
+	net.RemoveIdent()
+	net.connectionLost("Connection lost")
 	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetData, Object: messages.NetData{Name: net.GetName(), Connected: false}})
 }
 
-func (net *netImpl) joinpart(user, channel string, part bool) {
+func (net *netImpl) joinpart(member *messages.Member, channel string, part bool) {
 	ci := net.ChannelInfo.get(channel)
 	if ci == nil {
 		net.ChannelInfo.Put(&chanDataImpl{Network: net.Name, Name: channel})
 		net.Owner.HostConf.Autosave()
 		ci = net.ChannelInfo.get(channel)
 	}
-	contains, i := ci.UserList.Contains(user)
-	if contains {
+	rules := net.userRules()
+	if ci.Members.Find(member.Nick, rules) != nil {
 		if part {
-			ci.UserList[i] = ci.UserList[len(ci.UserList)-1]
-			ci.UserList = ci.UserList[:len(ci.UserList)-1]
+			ci.Members = ci.Members.Remove(member.Nick, rules)
 		} else {
 			return
 		}
 	} else if !part {
-		ci.UserList = append(ci.UserList, user)
+		ci.Members = append(ci.Members, member)
 	}
-	sort.Sort(ci.UserList)
+	ci.Members.Sort(rules)
 	net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
 
-	if user == net.IRC.GetNick() && part {
+	if net.isOwnNick(member.Nick) && part {
 		net.ChannelInfo.Remove(channel)
 		net.Owner.HostConf.Autosave()
 	}
@@ -331,9 +355,16 @@ func (net *netImpl) whoisChannels(evt *msg.Message) {
 		if len(ch) <= 0 {
 			continue
 		}
+		// With multi-prefix, all the prefixes of the user are included, the highest first
 		var prefix string
-		if userlist.LevelOfByte(ch[0]) > 0 {
-			prefix = userlist.NameOf(userlist.LevelOfByte(ch[0]))
+		for len(ch) > 1 && net.Support.ModeOfPrefix(rune(ch[0])) != 0 && (!net.Support.IsChannel(ch) || net.Support.IsChannel(ch[1:])) {
+			if len(prefix) == 0 {
+				mode := net.Support.ModeOfPrefix(rune(ch[0]))
+				prefix = userlist.NameOfMode(mode)
+				if len(prefix) == 0 {
+					prefix = string(mode)
+				}
+			}
 			ch = ch[1:]
 		}
 		data.Channels[ch] = prefix
@@ -0,0 +1,78 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	msg "github.com/sorcix/irc"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/util/casemap"
+	"maunium.net/go/mauirc-server/util/isupport"
+	"maunium.net/go/mauirc-server/util/userlist"
+)
+
+func (net *netImpl) GetSupport() *isupport.Support {
+	return net.Support
+}
+
+func (net *netImpl) getSupportTokens() map[string]string {
+	if net.Support == nil {
+		return nil
+	}
+	return net.Support.Tokens()
+}
+
+// userRules gets the rules of the server for comparing and sorting nicks
+func (net *netImpl) userRules() userlist.Rules {
+	return userlist.Rules{
+		Prefixes:    userlist.Prefixes(net.Support.Prefixes()),
+		CaseMapping: net.Support.CaseMapping(),
+	}
+}
+
+// caseMapping gets the casemapping of the server, or the default casemapping if the network hasn't connected yet
+func (net *netImpl) caseMapping() casemap.Mapping {
+	if net.Support == nil {
+		return casemap.Default
+	}
+	return net.Support.CaseMapping()
+}
+
+// setCaseMapping rekeys everything that is keyed by channel name with the given casemapping
+func (net *netImpl) setCaseMapping(mapping casemap.Mapping) {
+	net.ChannelInfo.SetCaseMapping(mapping)
+	net.rekeyChannelRetention(mapping)
+}
+
+// isOwnNick checks if the given nick is the current nick of the user on this network
+func (net *netImpl) isOwnNick(nick string) bool {
+	return net.Support.CaseMapping().Equal(nick, net.IRC.GetNick())
+}
+
+// isupport parses the features the server advertises in RPL_ISUPPORT (005)
+func (net *netImpl) isupport(evt *msg.Message) {
+	if len(evt.Params) < 2 {
+		return
+	}
+	tokens := evt.Params[1:]
+	if len(evt.Trailing) > 0 && tokens[len(tokens)-1] == evt.Trailing {
+		tokens = tokens[:len(tokens)-1]
+	}
+	net.Support.Parse(tokens)
+	net.setCaseMapping(net.Support.CaseMapping())
+	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetData, Object: net.GetNetData()})
+}
@@ -0,0 +1,79 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	msg "github.com/sorcix/irc"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/util/ircv3"
+)
+
+func init() {
+	// These capabilities only add details to the member lists, so they're always requested if available
+	for _, name := range []string{"multi-prefix", "userhost-in-names", "away-notify", "account-notify", "extended-join", "chghost"} {
+		ircv3.Register(ircv3.Capability{Name: name})
+	}
+}
+
+// updateMember calls the given function for the member with the given nick in
+// all channels and sends the changed channels to the clients.
+func (net *netImpl) updateMember(nick string, update func(member *messages.Member)) {
+	rules := net.userRules()
+	for _, ci := range net.ChannelInfo.channels {
+		if member := ci.Members.Find(nick, rules); member != nil {
+			update(member)
+			net.Owner.SendMessage(messages.Container{Type: messages.MsgChanData, Object: ci})
+		}
+	}
+}
+
+// away handles away-notify messages. An empty message means the user is back.
+func (net *netImpl) away(evt *msg.Message) {
+	message := evt.Trailing
+	if len(message) == 0 && len(evt.Params) > 0 {
+		message = evt.Params[len(evt.Params)-1]
+	}
+	net.updateMember(evt.Name, func(member *messages.Member) {
+		member.Away = message
+	})
+}
+
+// account handles account-notify messages. The account name * means the user logged out.
+func (net *netImpl) account(evt *msg.Message) {
+	if len(evt.Params) < 1 {
+		return
+	}
+	account := evt.Params[0]
+	if account == "*" {
+		account = ""
+	}
+	net.updateMember(evt.Name, func(member *messages.Member) {
+		member.Account = account
+	})
+}
+
+// chghost handles the user or host of a user changing
+func (net *netImpl) chghost(evt *msg.Message) {
+	if len(evt.Params) < 2 {
+		return
+	}
+	net.updateMember(evt.Name, func(member *messages.Member) {
+		member.User = evt.Params[0]
+		member.Host = evt.Params[1]
+	})
+}
@@ -18,10 +18,13 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"net"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	msg "github.com/sorcix/irc"
 	irc "maunium.net/go/libmauirc"
@@ -29,7 +32,11 @@ import (
 	"maunium.net/go/mauirc-server/database"
 	"maunium.net/go/mauirc-server/ident"
 	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/casemap"
+	"maunium.net/go/mauirc-server/util/ircv3"
+	"maunium.net/go/mauirc-server/util/isupport"
 	"maunium.net/go/mauirc-server/util/preview"
+	"maunium.net/go/mauirc-server/util/relay"
 	"maunium.net/go/mauirc-server/util/split"
 	"maunium.net/go/mauirc-server/util/userlist"
 	"maunium.net/go/maulogger"
@@ -46,31 +53,79 @@ type netImpl struct {
 	SSL      bool     `yaml:"ssl" json:"ssl"`
 	Chs      []string `yaml:"channels" json:"channels"`
 
+	SASL *interfaces.SASL      `yaml:"sasl,omitempty" json:"sasl,omitempty"`
+	TLS  *interfaces.TLS       `yaml:"tls,omitempty" json:"tls,omitempty"`
+	STS  *interfaces.STSPolicy `yaml:"sts,omitempty" json:"sts,omitempty"`
+
+	Servers   []interfaces.Server   `yaml:"servers,omitempty" json:"servers,omitempty"`
+	Reconnect *interfaces.Reconnect `yaml:"reconnect,omitempty" json:"reconnect,omitempty"`
+	// Bind is the local address or hostname to connect from. Overrides the bind address of the user.
+	Bind  string            `yaml:"bind,omitempty" json:"bind,omitempty"`
+	Proxy *interfaces.Proxy `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+
+	Retention        *interfaces.Retention            `yaml:"retention,omitempty" json:"retention,omitempty"`
+	ChannelRetention map[string]*interfaces.Retention `yaml:"channel-retention,omitempty" json:"channel-retention,omitempty"`
+	// retentionLock guards Retention and ChannelRetention. The map is replaced instead
+	// of modified, so a map that has been read can be used without the lock.
+	retentionLock sync.RWMutex
+
 	Owner       *userImpl                      `yaml:"-" json:"-"`
 	IRC         irc.Connection                 `yaml:"-" json:"-"`
+	Caps        *ircv3.Negotiator              `yaml:"-" json:"-"`
+	Support     *isupport.Support              `yaml:"-" json:"-"`
+	SASLSession *saslSession                   `yaml:"-" json:"-"`
+	SASLStatus  saslStatus                     `yaml:"-" json:"-"`
+	Address     connAddress                    `yaml:"-" json:"-"`
+	STSUpgrade  *stsUpgrade                    `yaml:"-" json:"-"`
+	Conn        connState                      `yaml:"-" json:"-"`
 	Scripts     []interfaces.Script            `yaml:"-" json:"-"`
-	ChannelInfo cdlImpl                        `yaml:"-" json:"-"`
+	ChannelInfo *cdlImpl                       `yaml:"-" json:"-"`
 	ChannelList []string                       `yaml:"-" json:"-"`
 	WhoisData   map[string]*messages.WhoisData `yaml:"-" json:"-"`
-	IdentPort   int                            `yaml:"-" json:"-"`
+	IdentKey    ident.Key                      `yaml:"-" json:"-"`
 	Sublogger   *maulogger.Sublogger           `yaml:"-" json:"-"`
+
+	// Tags contains the message tags of the received lines that haven't been handled yet
+	Tags tagQueue `yaml:"-" json:"-"`
 }
 
 func (net *netImpl) Save() {
 	net.Chs = []string{}
-	for ch := range net.ChannelInfo {
-		net.Chs = append(net.Chs, ch)
+	for _, ch := range net.ChannelInfo.channels {
+		net.Chs = append(net.Chs, ch.Name)
 	}
 }
 
 // Open an IRC connection
 func (net *netImpl) Open() {
-	i := irc.Create(net.Nick, net.User, irc.IPv4Address{IP: net.IP, Port: net.Port})
+	net.Sublogger = maulogger.CreateSublogger(net.Owner.GetNameFromEmail()+"/"+net.Name, maulogger.LevelDebug)
+
+	for _, ch := range net.Chs {
+		net.ChannelInfo.Put(&chanDataImpl{Network: net.Name, Name: ch})
+	}
+	net.WhoisData = make(map[string]*messages.WhoisData)
+	net.Support = isupport.New()
+
+	if err := net.Connect(); err != nil {
+		log.Errorf("Failed to connect to %s: %s\n", net.Address, err)
+	}
+}
+
+// newConnection creates an IRC connection with the current settings of the
+// network. The IRC library connects to the server through the returned relay.
+func (net *netImpl) newConnection() (irc.Connection, *relay.Relay, error) {
+	net.Address = net.address(net.currentServer())
+	net.Tags.reset()
+	rel, err := relay.Listen(net.Tags.filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := irc.Create(net.Nick, net.User, connAddress{IP: rel.IP(), Port: rel.Port()})
 	i.SetRealName(net.Realname)
 	i.SetQuitMessage("mauIRC server shutting down...")
-	i.SetUseTLS(net.SSL)
+	// TLS is set up by dialServer, the library only talks to the relay
+	i.SetUseTLS(false)
 
-	net.Sublogger = maulogger.CreateSublogger(net.Owner.GetNameFromEmail()+"/"+net.Name, maulogger.LevelDebug)
 	go func() {
 		for err := range i.Errors() {
 			net.Sublogger.Error(err.Error())
@@ -80,17 +135,23 @@ func (net *netImpl) Open() {
 		i.SetDebugWriter(net.Sublogger)
 	}
 
-	if len(net.Password) > 0 {
-		i.AddAuth(&irc.PasswordAuth{Password: net.Password})
-	}
-
-	for _, ch := range net.Chs {
-		net.ChannelInfo.Put(&chanDataImpl{Network: net.Name, Name: ch})
-	}
-	net.WhoisData = make(map[string]*messages.WhoisData)
-
-	net.IRC = i
-
+	net.Caps = ircv3.NewNegotiator(net, i.Send, net.capsChanged)
+	net.Support = isupport.New()
+	net.setCaseMapping(net.Support.CaseMapping())
+	i.AddAuth(&registrationAuth{net: net})
+
+	i.AddHandler(msg.CAP, net.Caps.Handle)
+	i.AddHandler("410", net.Caps.HandleInvalid)
+	i.AddHandler("005", net.isupport)
+	i.AddHandler(msg.AUTHENTICATE, net.authenticate)
+	i.AddHandler("900", net.loggedIn)
+	i.AddHandler("902", net.saslFailed)
+	i.AddHandler("903", net.saslSucceeded)
+	i.AddHandler("904", net.saslFailed)
+	i.AddHandler("905", net.saslFailed)
+	i.AddHandler("906", net.saslFailed)
+	// ERR_SASLALREADY means the connection is already authenticated
+	i.AddHandler("907", net.saslSucceeded)
 	i.AddHandler(msg.PRIVMSG, net.privmsg)
 	i.AddHandler(msg.NOTICE, net.privmsg)
 	i.AddHandler(msg.INVITE, net.invite)
@@ -106,7 +167,15 @@ func (net *netImpl) Open() {
 	i.AddHandler(msg.TOPIC, net.topic)
 	i.AddHandler(msg.NICK, net.nick)
 	i.AddHandler(msg.QUIT, net.quit)
-	i.AddHandler("DISCONNECTED", net.disconnected)
+	i.AddHandler(msg.AWAY, net.away)
+	i.AddHandler("ACCOUNT", net.account)
+	i.AddHandler("CHGHOST", net.chghost)
+	i.AddHandler("DISCONNECTED", func(evt *msg.Message) {
+		// Old connections may still send events after a new one has been opened
+		if net.IRC == i {
+			net.disconnected(evt)
+		}
+	})
 	i.AddHandler(msg.RPL_WELCOME, net.connected)
 	i.AddHandler(msg.RPL_NAMREPLY, net.userlist)
 	i.AddHandler(msg.RPL_ENDOFNAMES, net.userlistend)
@@ -124,54 +193,38 @@ func (net *netImpl) Open() {
 	i.AddHandler(msg.RPL_WHOISCHANNELS, net.whoisChannels)
 	i.AddHandler("617", net.whoisSecure)
 	i.AddHandler("*", net.rawHandler)
-
-	if err := net.Connect(); err != nil {
-		log.Errorf("Failed to connect to %s:%d: %s\n", net.IP, net.Port, err)
-	}
+	return i, rel, nil
 }
 
-func (net *netImpl) AddIdent() error {
-	addr := strings.Split(net.IRC.LocalAddr().String(), ":")
-	if len(addr) < 0 {
-		return fmt.Errorf("Invalid local address (%s)", net.IRC.LocalAddr().String())
-	}
-
-	port, err := strconv.Atoi(addr[1])
-	if err != nil {
-		return fmt.Errorf("Invalid port (%s): %s", addr[1], err)
-	}
-
-	ident.Ports[port] = net.Owner.GetNameFromEmail()
+// AddIdent adds the ident mapping for the connection to the server from the given local address
+func (net *netImpl) AddIdent(addr net.Addr) error {
+	key, err := ident.Add(addr, net.Owner.GetNameFromEmail())
 	if err != nil {
-		return fmt.Errorf("Failed to add ident: %s", err)
+		return fmt.Errorf("Failed to add ident for %s: %s", addr.String(), err)
 	}
-	net.IdentPort = port
-	log.Debugf("Added ident %d -> %s (%s)\n", port, net.Owner.GetNameFromEmail(), net.IRC.LocalAddr().String())
-
+	net.IdentKey = key
+	log.Debugf("Added ident %d -> %s (%s)\n", key.Port, net.Owner.GetNameFromEmail(), addr.String())
 	return nil
 }
 
 func (net *netImpl) RemoveIdent() bool {
-	if net.IdentPort == 0 {
+	if net.IdentKey.Port == 0 {
 		return false
 	}
 
-	delete(ident.Ports, net.IdentPort)
-	log.Debugf("Deleted ident %d -> %s\n", net.IdentPort, net.Owner.GetNameFromEmail())
-	net.IdentPort = 0
+	net.removeIdent(net.IdentKey)
+	net.IdentKey = ident.Key{}
 	return true
 }
 
-func (net *netImpl) Connect() error {
-	err := net.IRC.Connect()
-	if err != nil {
-		return err
-	}
-	return net.AddIdent()
+func (net *netImpl) removeIdent(key ident.Key) {
+	ident.Remove(key)
+	log.Debugf("Deleted ident %d -> %s\n", key.Port, net.Owner.GetNameFromEmail())
 }
 
 // Close the IRC connection.
 func (net *netImpl) Disconnect() {
+	net.stop(messages.StateDisconnected, "")
 	if net.IRC.Connected() {
 		net.IRC.Quit()
 		net.RemoveIdent()
@@ -179,6 +232,14 @@ func (net *netImpl) Disconnect() {
 }
 
 func (net *netImpl) ForceDisconnect() {
+	net.stop(messages.StateDisconnected, "")
+	net.IRC.Disconnect()
+	net.RemoveIdent()
+}
+
+// abort closes the connection without reconnecting because of an error
+func (net *netImpl) abort(reason string) {
+	net.stop(messages.StateFailed, reason)
 	net.IRC.Disconnect()
 	net.RemoveIdent()
 }
@@ -189,9 +250,14 @@ func (net *netImpl) IsConnected() bool {
 
 // ReceiveMessage stores the message and sends it to the client
 func (net *netImpl) ReceiveMessage(channel, sender, command, message string) {
-	msg := messages.Message{Network: net.Name, Channel: channel, Timestamp: time.Now().Unix(), Sender: sender, Command: command, Message: message}
+	net.receive(channel, sender, command, message, time.Now(), nil)
+}
+
+// receive stores the message with the given time and tags and sends it to the client
+func (net *netImpl) receive(channel, sender, command, message string, timestamp time.Time, tags map[string]string) {
+	msg := messages.Message{Network: net.Name, Channel: channel, Timestamp: messages.UnixMilli(timestamp), Sender: sender, Command: command, Message: message, Tags: tags}
 
-	if msg.Sender == net.IRC.GetNick() || (command == "nick" && message == net.IRC.GetNick()) {
+	if net.isOwnNick(msg.Sender) || (command == "nick" && net.isOwnNick(message)) {
 		msg.OwnMsg = true
 	} else {
 		msg.OwnMsg = false
@@ -199,11 +265,12 @@ func (net *netImpl) ReceiveMessage(channel, sender, command, message string) {
 
 	if msg.Channel == "AUTH" || msg.Channel == "*" {
 		return
-	} else if msg.Channel == net.IRC.GetNick() {
-		if len(msg.Sender) > 0 && net.GetActiveChannels().Has(msg.Sender) {
-			net.GetActiveChannels().Put(&chanDataImpl{Network: net.Name, Name: msg.Sender})
-		}
+	} else if net.isOwnNick(msg.Channel) {
 		msg.Channel = msg.Sender
+		// Keep using the name of an open query even if the nick is written in a different case
+		if query := net.ChannelInfo.get(msg.Sender); len(msg.Sender) > 0 && query != nil {
+			msg.Channel = query.Name
+		}
 	}
 
 	var evt = &interfaces.Event{Message: msg, Network: net, Cancelled: false}
@@ -218,7 +285,7 @@ func (net *netImpl) ReceiveMessage(channel, sender, command, message string) {
 
 // SendMessage sends the given message to the given channel
 func (net *netImpl) SendMessage(channel, command, message string) {
-	msg := messages.Message{Network: net.Name, Channel: channel, Timestamp: time.Now().Unix(), Sender: net.IRC.GetNick(), Command: command, Message: message, OwnMsg: true}
+	msg := messages.Message{Network: net.Name, Channel: channel, Timestamp: messages.UnixMilli(time.Now()), Sender: net.IRC.GetNick(), Command: command, Message: message, OwnMsg: true}
 
 	var evt = &interfaces.Event{Message: msg, Network: net, Cancelled: false}
 	net.RunScripts(evt, true)
@@ -249,13 +316,13 @@ func (net *netImpl) sendToIRC(msg messages.Message) bool {
 			net.IRC.Action(msg.Channel, msg.Message)
 			return true
 		case "topic":
-			net.IRC.Topic(msg.Channel, msg.Message)
+			net.IRC.Topic(msg.Channel, truncate(msg.Message, net.Support.TopicLen()))
 		case "join":
 			net.IRC.Join(msg.Channel, "")
 		case "part":
 			net.IRC.Part(msg.Channel, msg.Message)
 		case "nick":
-			net.IRC.SetNick(msg.Message)
+			net.IRC.SetNick(truncate(msg.Message, net.Support.NickLen()))
 		case "whois":
 			net.IRC.Whois(msg.Channel)
 		case "invite":
@@ -265,6 +332,18 @@ func (net *netImpl) sendToIRC(msg messages.Message) bool {
 	return false
 }
 
+// truncate cuts the given string to at most the given length in bytes without
+// splitting characters. Zero length means no limit.
+func truncate(str string, length int) string {
+	if length <= 0 || len(str) <= length {
+		return str
+	}
+	for length > 0 && !utf8.RuneStart(str[length]) {
+		length--
+	}
+	return str[:length]
+}
+
 // SwitchNetwork sends the given message to another network
 func (net *netImpl) SwitchMessageNetwork(msg messages.Message, receiving bool) bool {
 	newNet := net.Owner.GetNetwork(msg.Network)
@@ -285,8 +364,13 @@ func (net *netImpl) InsertAndSend(msg messages.Message) {
 		return
 	}
 	msg.Preview, _ = preview.GetPreview(msg.Message)
-	msg.ID = database.Insert(net.Owner.Email, msg)
-	net.Owner.SendMessage(messages.Container{Type: messages.MsgMessage, Object: msg})
+	database.QueueInsert(net.Owner.Email, msg, func(msg messages.Message, err error) {
+		if err != nil {
+			// The message is still sent to the client, but it doesn't have an ID.
+			net.Sublogger.Warnf("Failed to store message in %s: %s\n", msg.Channel, err)
+		}
+		net.Owner.SendMessage(messages.Container{Type: messages.MsgMessage, Object: msg})
+	})
 }
 
 func (net *netImpl) GetOwner() interfaces.User {
@@ -302,7 +386,9 @@ func (net *netImpl) GetNick() string {
 }
 
 func (net *netImpl) SetName(name string) {
+	oldName := net.Name
 	net.Name = name
+	net.renameClientCert(oldName)
 	net.Sublogger.SetModule(net.Owner.GetNameFromEmail() + "/" + name)
 	net.Owner.HostConf.Autosave()
 }
@@ -352,7 +438,40 @@ func (net *netImpl) GetNetData() messages.NetData {
 		Realname:  net.Realname,
 		Nick:      net.Nick,
 		Connected: net.IsConnected(),
+
+		Capabilities: net.GetCapabilities(),
+		ISupport:     net.getSupportTokens(),
+		SASL:         net.getSASLMechanism(),
+		Proxy:        net.getProxyType(),
+		State:        net.getState(),
+	}
+}
+
+func (net *netImpl) getSASLMechanism() string {
+	if net.SASL == nil {
+		return ""
 	}
+	return strings.ToUpper(net.SASL.Mechanism)
+}
+
+func (net *netImpl) GetSASL() *interfaces.SASL {
+	return net.SASL
+}
+
+func (net *netImpl) SetSASL(sasl *interfaces.SASL) {
+	net.SASL = sasl
+	net.Owner.HostConf.Autosave()
+}
+
+func (net *netImpl) HasCapability(name string) bool {
+	return net.Caps != nil && net.Caps.IsEnabled(name)
+}
+
+func (net *netImpl) GetCapabilities() []string {
+	if net.Caps == nil {
+		return nil
+	}
+	return net.Caps.List()
 }
 
 func (net *netImpl) GetActiveChannels() interfaces.ChannelDataList {
@@ -363,6 +482,80 @@ func (net *netImpl) GetAllChannels() []string {
 	return net.ChannelList
 }
 
+func (net *netImpl) GetRetention() *interfaces.Retention {
+	net.retentionLock.RLock()
+	defer net.retentionLock.RUnlock()
+	return net.Retention
+}
+
+func (net *netImpl) SetRetention(r *interfaces.Retention) {
+	net.retentionLock.Lock()
+	net.Retention = r
+	net.retentionLock.Unlock()
+	net.Owner.HostConf.Autosave()
+}
+
+func (net *netImpl) GetChannelRetention(channel string) *interfaces.Retention {
+	return net.GetChannelRetentions()[net.caseMapping().ToLower(channel)]
+}
+
+func (net *netImpl) SetChannelRetention(channel string, r *interfaces.Retention) {
+	channel = net.caseMapping().ToLower(channel)
+	net.retentionLock.Lock()
+	var policies = make(map[string]*interfaces.Retention, len(net.ChannelRetention)+1)
+	for name, policy := range net.ChannelRetention {
+		policies[name] = policy
+	}
+	if r == nil {
+		delete(policies, channel)
+	} else {
+		policies[channel] = r
+	}
+	net.ChannelRetention = policies
+	net.retentionLock.Unlock()
+	net.Owner.HostConf.Autosave()
+}
+
+// rekeyChannelRetention rekeys the channel retention policies with the given
+// casemapping. If several policies turn out to be for the same channel, the
+// strictest limits of them are kept.
+func (net *netImpl) rekeyChannelRetention(mapping casemap.Mapping) {
+	net.retentionLock.Lock()
+	defer net.retentionLock.Unlock()
+	var policies = make(map[string]*interfaces.Retention, len(net.ChannelRetention))
+	for name, policy := range net.ChannelRetention {
+		key := mapping.ToLower(name)
+		if existing, ok := policies[key]; ok {
+			policy = mergeRetention(existing, policy)
+		}
+		policies[key] = policy
+	}
+	net.ChannelRetention = policies
+}
+
+// mergeRetention combines two retention policies into one with the strictest limits of both
+func mergeRetention(a, b *interfaces.Retention) *interfaces.Retention {
+	return &interfaces.Retention{
+		MaxAge:      minLimit(a.MaxAge, b.MaxAge),
+		MaxMessages: minLimit(a.MaxMessages, b.MaxMessages),
+	}
+}
+
+// minLimit returns the smaller of the given limits. Zero means no limit.
+func minLimit(a, b int) int {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// GetChannelRetentions gets the retention policies of the channels. The map must not be modified.
+func (net *netImpl) GetChannelRetentions() map[string]*interfaces.Retention {
+	net.retentionLock.RLock()
+	defer net.retentionLock.RUnlock()
+	return net.ChannelRetention
+}
+
 func (net *netImpl) Tunnel() irc.Tunnel {
 	return net.IRC
 }
@@ -395,16 +588,17 @@ func (net *netImpl) RemoveScript(name string) bool {
 }
 
 func (net *netImpl) GetWhoisData(name string) *messages.WhoisData {
-	data, ok := net.WhoisData[name]
+	key := net.Support.CaseMapping().ToLower(name)
+	data, ok := net.WhoisData[key]
 	if !ok {
-		net.WhoisData[name] = &messages.WhoisData{Nick: name, Channels: make(map[string]string)}
-		return net.WhoisData[name]
+		net.WhoisData[key] = &messages.WhoisData{Nick: name, Channels: make(map[string]string)}
+		return net.WhoisData[key]
 	}
 	return data
 }
 
 func (net *netImpl) GetWhoisDataIfExists(name string) *messages.WhoisData {
-	data, ok := net.WhoisData[name]
+	data, ok := net.WhoisData[net.Support.CaseMapping().ToLower(name)]
 	if !ok {
 		return nil
 	}
@@ -412,23 +606,52 @@ func (net *netImpl) GetWhoisDataIfExists(name string) *messages.WhoisData {
 }
 
 func (net *netImpl) RemoveWhoisData(name string) {
-	net.WhoisData[name] = nil
-	delete(net.WhoisData, name)
+	key := net.Support.CaseMapping().ToLower(name)
+	net.WhoisData[key] = nil
+	delete(net.WhoisData, key)
 }
 
 type chanDataImpl struct {
-	Network           string              `yaml:"network" json:"network"`
-	Name              string              `yaml:"name" json:"name"`
-	UserList          userlist.List       `yaml:"userlist" json:"userlist"`
-	Topic             string              `yaml:"topic" json:"topic"`
-	TopicSetBy        string              `yaml:"topicsetby" json:"topicsetby"`
-	TopicSetAt        int64               `yaml:"topicsetat" json:"topicsetat"`
-	ModeList          interfaces.ModeList `yaml:"modes" json:"modes"`
-	ReceivingUserList bool                `yaml:"-" json:"-"`
+	Network    string              `yaml:"network" json:"network"`
+	Name       string              `yaml:"name" json:"name"`
+	Members    userlist.List       `yaml:"members" json:"members"`
+	Topic      string              `yaml:"topic" json:"topic"`
+	TopicSetBy string              `yaml:"topicsetby" json:"topicsetby"`
+	TopicSetAt int64               `yaml:"topicsetat" json:"topicsetat"`
+	ModeList   interfaces.ModeList `yaml:"modes" json:"modes"`
+	// newMembers contains the members received so far in an ongoing NAMES reply
+	newMembers userlist.List
+}
+
+// MarshalJSON adds the list of prefixed nicks for clients that don't use the member details
+func (cd *chanDataImpl) MarshalJSON() ([]byte, error) {
+	type chanData chanDataImpl
+	return json.Marshal(struct {
+		*chanData
+		UserList []string `json:"userlist"`
+	}{(*chanData)(cd), cd.Members.Nicks()})
+}
+
+// ForProtocol converts the topic time into seconds for old protocol versions
+func (cd *chanDataImpl) ForProtocol(version int) interface{} {
+	if version >= messages.ProtocolMilliseconds {
+		return cd
+	}
+	converted := *cd
+	converted.TopicSetAt /= 1000
+	return &converted
 }
 
 func (cd *chanDataImpl) GetUsers() []string {
-	return cd.UserList
+	return cd.Members.Nicks()
+}
+
+func (cd *chanDataImpl) GetMembers() []messages.Member {
+	var members = make([]messages.Member, len(cd.Members))
+	for i, member := range cd.Members {
+		members[i] = *member
+	}
+	return members
 }
 
 func (cd *chanDataImpl) GetName() string {
@@ -447,35 +670,80 @@ func (cd *chanDataImpl) Modes() interfaces.ModeList {
 	return cd.ModeList
 }
 
-type cdlImpl map[string]*chanDataImpl
+// cdlImpl is a list of channels and queries keyed by their names in lowercase
+// according to the casemapping of the server.
+type cdlImpl struct {
+	channels map[string]*chanDataImpl
+	mapping  casemap.Mapping
+}
+
+func newChannelDataList() *cdlImpl {
+	return &cdlImpl{channels: make(map[string]*chanDataImpl), mapping: casemap.Default}
+}
+
+// SetCaseMapping changes the casemapping and rekeys the channels. Channels
+// that are the same channel under the new casemapping are merged.
+func (cdl *cdlImpl) SetCaseMapping(mapping casemap.Mapping) {
+	if mapping == cdl.mapping {
+		return
+	}
+	cdl.mapping = mapping
+	var channels = make(map[string]*chanDataImpl, len(cdl.channels))
+	for _, data := range cdl.channels {
+		key := mapping.ToLower(data.Name)
+		if existing, ok := channels[key]; ok {
+			log.Debugf("Merging channels %s and %s of %s after casemapping change\n", existing.Name, data.Name, data.Network)
+			data = mergeChannels(existing, data, userlist.Rules{CaseMapping: mapping})
+		}
+		channels[key] = data
+	}
+	cdl.channels = channels
+}
+
+// mergeChannels merges the data of two channels that turned out to be the same
+// channel. The topic of the channel with the newer topic is kept.
+func mergeChannels(a, b *chanDataImpl, rules userlist.Rules) *chanDataImpl {
+	if b.TopicSetAt > a.TopicSetAt {
+		a, b = b, a
+	}
+	for _, member := range b.Members {
+		if a.Members.Find(member.Nick, rules) == nil {
+			a.Members = append(a.Members, member)
+		}
+	}
+	for _, mode := range b.ModeList {
+		a.ModeList = a.ModeList.AddMode(mode.Mode, mode.Target)
+	}
+	return a
+}
 
-func (cdl cdlImpl) Get(channel string) (interfaces.ChannelData, bool) {
-	val, ok := cdl[strings.ToLower(channel)]
+func (cdl *cdlImpl) Get(channel string) (interfaces.ChannelData, bool) {
+	val, ok := cdl.channels[cdl.mapping.ToLower(channel)]
 	return val, ok
 }
 
-func (cdl cdlImpl) get(channel string) *chanDataImpl {
-	return cdl[strings.ToLower(channel)]
+func (cdl *cdlImpl) get(channel string) *chanDataImpl {
+	return cdl.channels[cdl.mapping.ToLower(channel)]
 }
 
-func (cdl cdlImpl) Put(data interfaces.ChannelData) {
+func (cdl *cdlImpl) Put(data interfaces.ChannelData) {
 	dat, ok := data.(*chanDataImpl)
 	if ok {
-		cdl[strings.ToLower(data.GetName())] = dat
+		cdl.channels[cdl.mapping.ToLower(data.GetName())] = dat
 	}
 }
 
-func (cdl cdlImpl) Remove(channel string) {
-	delete(cdl, strings.ToLower(channel))
+func (cdl *cdlImpl) Remove(channel string) {
+	delete(cdl.channels, cdl.mapping.ToLower(channel))
 }
 
-func (cdl cdlImpl) Has(channel string) bool {
-	_, ok := cdl[strings.ToLower(channel)]
+func (cdl *cdlImpl) Has(channel string) bool {
+	_, ok := cdl.channels[cdl.mapping.ToLower(channel)]
 	return ok
 }
 
-func (cdl cdlImpl) ForEach(do func(interfaces.ChannelData)) {
-	for _, val := range cdl {
+func (cdl *cdlImpl) ForEach(do func(interfaces.ChannelData)) {
+	for _, val := range cdl.channels {
 		do(val)
 	}
 }
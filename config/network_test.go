@@ -0,0 +1,83 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/casemap"
+	"maunium.net/go/mauirc-server/util/userlist"
+)
+
+func TestMergeChannels(t *testing.T) {
+	older := &chanDataImpl{
+		Name:       "#foo[1]",
+		Topic:      "old topic",
+		TopicSetAt: 1,
+		Members:    userlist.List{{Nick: "alice"}, {Nick: "Bob"}},
+		ModeList:   interfaces.ModeList{{Mode: 'n'}, {Mode: 'o', Target: "alice"}},
+	}
+	newer := &chanDataImpl{
+		Name:       "#foo{1}",
+		Topic:      "new topic",
+		TopicSetAt: 2,
+		Members:    userlist.List{{Nick: "bob"}, {Nick: "carol"}},
+		ModeList:   interfaces.ModeList{{Mode: 'n'}, {Mode: 't'}},
+	}
+
+	merged := mergeChannels(older, newer, userlist.Rules{CaseMapping: casemap.RFC1459})
+	if merged.Topic != "new topic" {
+		t.Errorf("Expected the newer topic to be kept, got %q", merged.Topic)
+	}
+	if nicks := merged.Members.Nicks(); len(nicks) != 3 {
+		t.Errorf("Expected alice, bob and carol, got %v", nicks)
+	}
+	for _, mode := range []interfaces.Mode{{Mode: 'n'}, {Mode: 't'}, {Mode: 'o', Target: "alice"}} {
+		if !merged.ModeList.HasMode(mode.Mode, mode.Target) {
+			t.Errorf("Expected the merged channel to have mode %c %s", mode.Mode, mode.Target)
+		}
+	}
+	if len(merged.ModeList) != 3 {
+		t.Errorf("Expected 3 modes, got %v", merged.ModeList)
+	}
+}
+
+func TestSetCaseMapping(t *testing.T) {
+	cdl := newChannelDataList()
+	cdl.SetCaseMapping(casemap.ASCII)
+	cdl.Put(&chanDataImpl{Name: "#Foo[1]", TopicSetAt: 1, Members: userlist.List{{Nick: "alice"}}})
+	cdl.Put(&chanDataImpl{Name: "#foo{1}", TopicSetAt: 2, Members: userlist.List{{Nick: "bob"}}})
+	cdl.Put(&chanDataImpl{Name: "#Bar"})
+	if len(cdl.channels) != 3 {
+		t.Fatalf("Expected 3 channels with the ascii casemapping, got %d", len(cdl.channels))
+	}
+
+	cdl.SetCaseMapping(casemap.RFC1459)
+	if len(cdl.channels) != 2 {
+		t.Fatalf("Expected 2 channels with the rfc1459 casemapping, got %d", len(cdl.channels))
+	}
+	foo := cdl.get("#FOO[1]")
+	if foo == nil {
+		t.Fatal("Expected #FOO[1] to be found with the rfc1459 casemapping")
+	} else if foo.Name != "#foo{1}" || len(foo.Members) != 2 {
+		t.Errorf("Expected the channels to be merged into #foo{1}, got %s with %v", foo.Name, foo.Members.Nicks())
+	}
+	if _, ok := cdl.Get("#bar"); !ok {
+		t.Error("Expected #bar to be found after rekeying")
+	}
+}
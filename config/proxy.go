@@ -0,0 +1,51 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"strings"
+
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/proxy"
+)
+
+func (net *netImpl) GetProxy() *interfaces.Proxy {
+	return net.Proxy
+}
+
+func (net *netImpl) SetProxy(settings *interfaces.Proxy) {
+	net.Proxy = settings
+	net.Owner.HostConf.Autosave()
+}
+
+func (net *netImpl) getProxyType() string {
+	if net.Proxy == nil {
+		return ""
+	}
+	return strings.ToLower(net.Proxy.Type)
+}
+
+// proxyConfig converts the proxy settings of the network into the format of the proxy package
+func (net *netImpl) proxyConfig() proxy.Config {
+	return proxy.Config{
+		Type:     net.Proxy.Type,
+		Address:  net.Proxy.Address,
+		Username: net.Proxy.Username,
+		Password: net.Proxy.Password,
+	}
+}
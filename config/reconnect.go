@@ -0,0 +1,278 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/interfaces"
+)
+
+// Defaults for the reconnection policy in seconds
+const (
+	DefaultReconnectMinDelay = 5
+	DefaultReconnectMaxDelay = 300
+)
+
+// reconnectJitter is the maximum fraction by which the delay is randomly changed
+const reconnectJitter = 0.2
+
+// fatalError is an error that connecting again can't fix, such as settings
+// that can't be applied. The network isn't reconnected after one.
+type fatalError struct {
+	error
+}
+
+// connState is the state of the connection to a network
+type connState struct {
+	lock  sync.Mutex
+	state string
+	// attempt is the number of failed attempts in a row
+	attempt int
+	// server is the index of the server to connect to in the server list
+	server int
+	// timer is the pending reconnection attempt
+	timer *time.Timer
+	// stopped is true if the user disconnected, so the connection shouldn't be reopened
+	stopped bool
+}
+
+func (net *netImpl) GetServers() []interfaces.Server {
+	return net.Servers
+}
+
+func (net *netImpl) SetServers(servers []interfaces.Server) {
+	net.Servers = servers
+	net.Owner.HostConf.Autosave()
+}
+
+func (net *netImpl) GetReconnect() *interfaces.Reconnect {
+	return net.Reconnect
+}
+
+func (net *netImpl) SetReconnect(policy *interfaces.Reconnect) {
+	net.Reconnect = policy
+	net.Owner.HostConf.Autosave()
+}
+
+// servers gets the main server followed by the alternate servers
+func (net *netImpl) servers() []interfaces.Server {
+	return append([]interfaces.Server{{IP: net.IP, Port: net.Port, SSL: net.SSL}}, net.Servers...)
+}
+
+// currentServer gets the server the next connection attempt is made to
+func (net *netImpl) currentServer() interfaces.Server {
+	servers := net.servers()
+	return servers[net.Conn.server%len(servers)]
+}
+
+// Connect opens a new connection to the network, starting from the main server
+func (net *netImpl) Connect() error {
+	net.Conn.lock.Lock()
+	if net.IRC != nil && net.IRC.Connected() {
+		net.Conn.lock.Unlock()
+		return nil
+	}
+	net.Conn.stopTimer()
+	net.Conn.stopped = false
+	net.Conn.attempt = 0
+	net.Conn.server = 0
+	net.Conn.lock.Unlock()
+	return net.tryConnect()
+}
+
+// tryConnect attempts to connect to the current server and schedules the next
+// attempt if it fails.
+func (net *netImpl) tryConnect() error {
+	net.Conn.lock.Lock()
+	if net.Conn.stopped {
+		net.Conn.lock.Unlock()
+		return nil
+	}
+	conn, rel, err := net.newConnection()
+	if err != nil {
+		net.Conn.lock.Unlock()
+		net.connectionLost("Failed to connect: " + err.Error())
+		return err
+	}
+	net.IRC = conn
+	net.setState(messages.StateConnecting, "", 0)
+	addr := net.Address
+	net.Conn.lock.Unlock()
+
+	localAddr, err := net.openConnection(conn, rel, addr)
+	if _, fatal := err.(fatalError); fatal {
+		net.Sublogger.Errorln("Can't connect:", err)
+		net.stop(messages.StateFailed, err.Error())
+		return err
+	} else if err != nil {
+		net.connectionLost("Failed to connect: " + err.Error())
+		return err
+	}
+	return net.AddIdent(localAddr)
+}
+
+// connectionLost schedules a reconnection attempt according to the
+// reconnection policy of the network, unless the user disconnected.
+func (net *netImpl) connectionLost(reason string) {
+	net.Conn.lock.Lock()
+	defer net.Conn.lock.Unlock()
+	if net.Conn.stopped || net.Conn.timer != nil {
+		return
+	}
+
+	policy := net.reconnectPolicy()
+	net.Conn.attempt++
+	if policy.Disabled || (policy.MaxAttempts > 0 && net.Conn.attempt >= policy.MaxAttempts) {
+		net.setState(messages.StateFailed, reason, 0)
+		return
+	}
+
+	// Try the next server in the list
+	net.Conn.server++
+	delay := backoff(policy, net.Conn.attempt)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		net.Conn.lock.Lock()
+		if net.Conn.timer != timer {
+			net.Conn.lock.Unlock()
+			return
+		}
+		net.Conn.timer = nil
+		net.Conn.lock.Unlock()
+		net.tryConnect()
+	})
+	net.Conn.timer = timer
+	net.setState(messages.StateBackoff, reason, messages.UnixMilli(time.Now().Add(delay)))
+}
+
+// registered resets the backoff after the connection has been registered
+func (net *netImpl) registered() {
+	net.Conn.lock.Lock()
+	defer net.Conn.lock.Unlock()
+	net.Conn.attempt = 0
+	net.stsUpgraded()
+	net.setState(messages.StateRegistered, "", 0)
+}
+
+// stop prevents reconnecting after the user has disconnected
+func (net *netImpl) stop(state, reason string) {
+	net.Conn.lock.Lock()
+	defer net.Conn.lock.Unlock()
+	net.Conn.stopTimer()
+	net.Conn.stopped = true
+	net.setState(state, reason, 0)
+}
+
+// reconnectNow replaces the current connection with a new one to the same server
+func (net *netImpl) reconnectNow() {
+	net.Conn.lock.Lock()
+	old, oldIdent := net.IRC, net.IdentKey
+	// A pending attempt would otherwise replace the new connection when it fires
+	net.Conn.stopTimer()
+	net.Conn.lock.Unlock()
+	// The new connection is opened first, so the disconnection of the old one isn't handled as a lost connection
+	err := net.tryConnect()
+	if err != nil {
+		net.Sublogger.Errorln("Failed to reconnect:", err)
+	}
+	if old != nil {
+		old.Disconnect()
+	}
+	if oldIdent.Port != 0 && oldIdent != net.IdentKey {
+		net.removeIdent(oldIdent)
+	}
+}
+
+func (net *netImpl) getState() string {
+	net.Conn.lock.Lock()
+	defer net.Conn.lock.Unlock()
+	return net.Conn.state
+}
+
+// setState changes the connection state and sends it to the clients. The lock must be held.
+func (net *netImpl) setState(state, reason string, nextAttempt int64) {
+	net.Conn.state = state
+	server := net.currentServer()
+	if state == messages.StateConnecting || state == messages.StateRegistered {
+		server = interfaces.Server{IP: net.Address.IP, Port: net.Address.Port, SSL: net.Address.SSL}
+	}
+	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetEvent, Object: messages.NetEvent{
+		Network:     net.Name,
+		Type:        messages.NetEventState,
+		Message:     reason,
+		State:       state,
+		Server:      hostPort(server),
+		Attempt:     net.Conn.attempt,
+		NextAttempt: nextAttempt,
+	}})
+}
+
+// String formats the address for dialing. IPv6 addresses are enclosed in brackets.
+func (addr connAddress) String() string {
+	return net.JoinHostPort(addr.IP, strconv.Itoa(int(addr.Port)))
+}
+
+// hostPort formats the address of the given server
+func hostPort(server interfaces.Server) string {
+	return net.JoinHostPort(server.IP, strconv.Itoa(int(server.Port)))
+}
+
+// stopTimer cancels the pending reconnection attempt. The lock must be held.
+func (cs *connState) stopTimer() {
+	if cs.timer != nil {
+		cs.timer.Stop()
+		cs.timer = nil
+	}
+}
+
+// reconnectPolicy gets the reconnection policy of the network with the defaults filled in
+func (net *netImpl) reconnectPolicy() interfaces.Reconnect {
+	var policy interfaces.Reconnect
+	if net.Reconnect != nil {
+		policy = *net.Reconnect
+	}
+	if policy.MinDelay <= 0 {
+		policy.MinDelay = DefaultReconnectMinDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultReconnectMaxDelay
+	}
+	if policy.MaxDelay < policy.MinDelay {
+		policy.MaxDelay = policy.MinDelay
+	}
+	return policy
+}
+
+// backoff calculates the delay before the given attempt
+func backoff(policy interfaces.Reconnect, attempt int) time.Duration {
+	delay := float64(policy.MinDelay)
+	for i := 1; i < attempt && delay < float64(policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	delay *= 1 + reconnectJitter*(2*rand.Float64()-1)
+	return time.Duration(delay * float64(time.Second))
+}
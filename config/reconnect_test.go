@@ -0,0 +1,68 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"maunium.net/go/mauirc-server/interfaces"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := interfaces.Reconnect{MinDelay: 5, MaxDelay: 60}
+	tests := []struct {
+		attempt  int
+		expected int
+	}{
+		{1, 5},
+		{2, 10},
+		{3, 20},
+		{4, 40},
+		{5, 60},
+		{50, 60},
+	}
+	for _, test := range tests {
+		expected := time.Duration(test.expected) * time.Second
+		min := time.Duration(float64(expected) * (1 - reconnectJitter))
+		max := time.Duration(float64(expected) * (1 + reconnectJitter))
+		for i := 0; i < 10; i++ {
+			if delay := backoff(policy, test.attempt); delay < min || delay > max {
+				t.Errorf("backoff(attempt %d) = %s, expected %s ± %.0f%%", test.attempt, delay, expected, reconnectJitter*100)
+				break
+			}
+		}
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *interfaces.Reconnect
+		expected interfaces.Reconnect
+	}{
+		{"defaults", nil, interfaces.Reconnect{MinDelay: DefaultReconnectMinDelay, MaxDelay: DefaultReconnectMaxDelay}},
+		{"custom", &interfaces.Reconnect{MinDelay: 1, MaxDelay: 2, MaxAttempts: 3}, interfaces.Reconnect{MinDelay: 1, MaxDelay: 2, MaxAttempts: 3}},
+		{"max below min", &interfaces.Reconnect{MinDelay: 30, MaxDelay: 10}, interfaces.Reconnect{MinDelay: 30, MaxDelay: 30}},
+	}
+	for _, test := range tests {
+		net := &netImpl{Reconnect: test.policy}
+		if policy := net.reconnectPolicy(); policy != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, policy)
+		}
+	}
+}
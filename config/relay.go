@@ -0,0 +1,73 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	irc "maunium.net/go/libmauirc"
+	"maunium.net/go/mauirc-server/util/relay"
+)
+
+// openConnection dials the server and connects the IRC library to it through
+// the relay. The local address of the connection to the server is returned.
+func (net *netImpl) openConnection(conn irc.Connection, rel *relay.Relay, addr connAddress) (net.Addr, error) {
+	server, err := net.dialServer(addr)
+	if err != nil {
+		rel.Close()
+		return nil, err
+	}
+
+	// Connect returns once the IRC library has opened its connection to the relay
+	err = conn.Connect()
+	if err == nil {
+		err = rel.Start(server, conn.LocalAddr())
+	}
+	if err != nil {
+		server.Close()
+		rel.Close()
+		return nil, err
+	}
+	return server.LocalAddr(), nil
+}
+
+// dialServer opens the connection to the given server. The connection is
+// opened here instead of the IRC library, so that the bind address, the proxy,
+// the client certificate and the TLS settings of the network are used.
+func (net *netImpl) dialServer(addr connAddress) (net.Conn, error) {
+	dialer, err := net.dialer(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.Dial("tcp", addr.String())
+	if err != nil || !addr.SSL {
+		return conn, err
+	}
+
+	tlsConn := tls.Client(conn, net.tlsConfig(addr))
+	tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,102 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"time"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
+	"maunium.net/go/mauirc-server/interfaces"
+)
+
+// DefaultPruneInterval is the default interval between history pruning runs in minutes
+const DefaultPruneInterval = 60
+
+func (config *configImpl) pruneLoop() {
+	interval := config.PruneInterval
+	if interval <= 0 {
+		interval = DefaultPruneInterval
+	}
+	for {
+		config.PruneHistory()
+		time.Sleep(time.Duration(interval) * time.Minute)
+	}
+}
+
+// PruneHistory applies the retention policies of all users
+func (config *configImpl) PruneHistory() {
+	var total int64
+	for _, user := range config.Users {
+		total += user.PruneHistory()
+	}
+	if total > 0 {
+		log.Infof("Pruned %d messages from history\n", total)
+	}
+}
+
+// PruneHistory deletes the messages that aren't covered by the retention policies
+// of the user and returns the number of messages deleted
+func (user *userImpl) PruneHistory() (total int64) {
+	channels, err := database.Channels(user.Email)
+	if err != nil {
+		log.Errorf("Failed to get history channels of %s: %s\n", user.Email, err)
+		return
+	}
+
+	for network, chans := range channels {
+		for _, channel := range chans {
+			policy := user.retentionFor(network, channel)
+			if policy == nil || policy.IsUnlimited() {
+				continue
+			}
+
+			var before int64
+			if policy.MaxAge > 0 {
+				before = messages.UnixMilli(time.Now().AddDate(0, 0, -policy.MaxAge))
+			}
+			deleted, err := database.Prune(user.Email, network, channel, before, policy.MaxMessages)
+			if err != nil {
+				log.Errorf("Failed to prune history of %s in %s @ %s: %s\n", user.Email, channel, network, err)
+				continue
+			} else if deleted > 0 {
+				log.Debugf("Pruned %d messages of %s in %s @ %s\n", deleted, user.Email, channel, network)
+			}
+			total += deleted
+		}
+	}
+	return
+}
+
+// retentionFor finds the most specific retention policy for the given channel.
+// Channel policies override network policies, which override user policies,
+// which override the server-wide policy.
+func (user *userImpl) retentionFor(network, channel string) *interfaces.Retention {
+	net, ok := user.GetNetwork(network).(*netImpl)
+	if ok && net != nil {
+		if policy := net.GetChannelRetention(channel); policy != nil {
+			return policy
+		} else if policy = net.GetRetention(); policy != nil {
+			return policy
+		}
+	}
+	if policy := user.GetRetention(); policy != nil {
+		return policy
+	}
+	return user.HostConf.Retention
+}
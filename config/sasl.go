@@ -0,0 +1,158 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	msg "github.com/sorcix/irc"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/ircv3"
+	"maunium.net/go/mauirc-server/util/sasl"
+)
+
+func init() {
+	ircv3.Register(ircv3.Capability{Name: "sasl", Want: wantSASL, Enabled: startSASL})
+}
+
+// saslStatus is the result of the SASL authentication of a connection
+type saslStatus int
+
+const (
+	saslPending saslStatus = iota
+	saslSucceeded
+	saslFailed
+)
+
+// saslSession is an ongoing SASL authentication
+type saslSession struct {
+	mech    sasl.Mechanism
+	decoder sasl.Decoder
+	caps    *ircv3.Negotiator
+}
+
+// wantSASL requests the sasl capability if the network has SASL configured
+// and the server supports the configured mechanism.
+func wantSASL(net interfaces.Network, value string) bool {
+	conf := net.GetSASL()
+	return conf != nil && len(conf.Mechanism) > 0 && sasl.Supported(conf.Mechanism, value)
+}
+
+// startSASL starts the authentication and holds the capability negotiation until it's done
+func startSASL(inet interfaces.Network, caps *ircv3.Negotiator, value string) {
+	net, ok := inet.(*netImpl)
+	if !ok || net.SASL == nil || !caps.Negotiating() {
+		return
+	}
+
+	mech, err := sasl.New(net.SASL.Mechanism, net.SASL.Username, net.SASL.Password)
+	if err != nil {
+		net.failSASL(err.Error())
+		return
+	}
+
+	caps.Hold()
+	net.SASLSession = &saslSession{mech: mech, caps: caps}
+	net.sendAuthenticate(mech.Name())
+}
+
+func (net *netImpl) sendAuthenticate(payload string) {
+	net.IRC.Send(&msg.Message{Command: msg.AUTHENTICATE, Params: []string{payload}})
+}
+
+func (net *netImpl) authenticate(evt *msg.Message) {
+	session := net.SASLSession
+	if session == nil || len(evt.Params) == 0 {
+		return
+	}
+
+	challenge, done, err := session.decoder.Add(evt.Params[len(evt.Params)-1])
+	if !done {
+		return
+	}
+	var response []byte
+	if err == nil {
+		response, err = session.mech.Next(challenge)
+	}
+	if err != nil {
+		// The server replies with ERR_SASLABORTED, which ends the session
+		net.Sublogger.Warnln("Aborting SASL authentication:", err)
+		net.sendAuthenticate("*")
+		return
+	}
+
+	for _, payload := range sasl.Encode(response) {
+		net.sendAuthenticate(payload)
+	}
+}
+
+func (net *netImpl) loggedIn(evt *msg.Message) {
+	if len(evt.Params) > 2 {
+		net.Sublogger.Infoln("Logged in as", evt.Params[2])
+	}
+}
+
+func (net *netImpl) saslSucceeded(evt *msg.Message) {
+	session := net.SASLSession
+	if session == nil {
+		return
+	}
+	net.SASLSession = nil
+	net.SASLStatus = saslSucceeded
+	session.caps.Release()
+}
+
+func (net *netImpl) saslFailed(evt *msg.Message) {
+	session := net.SASLSession
+	if session == nil {
+		return
+	}
+	net.SASLSession = nil
+	if !net.failSASL(evt.Trailing) {
+		session.caps.Release()
+	}
+}
+
+// checkSASL makes sure the authentication didn't silently fail because the
+// server doesn't support SASL or the configured mechanism. It's called when
+// the registration completes and returns false if the connection was aborted.
+func (net *netImpl) checkSASL() bool {
+	if net.SASL == nil || len(net.SASL.Mechanism) == 0 || net.SASLStatus != saslPending {
+		return true
+	}
+	return !net.failSASL("The server doesn't support SASL with " + net.getSASLMechanism())
+}
+
+// failSASL sends the reason of a failed authentication to the clients and
+// disconnects if the network is configured to abort on failures. The return
+// value tells whether the connection was aborted.
+func (net *netImpl) failSASL(reason string) bool {
+	net.SASLStatus = saslFailed
+	net.Sublogger.Warnln("SASL authentication failed:", reason)
+	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetEvent, Object: messages.NetEvent{
+		Network: net.Name,
+		Type:    messages.NetEventSASLFailed,
+		Message: reason,
+	}})
+
+	if net.SASL != nil && net.SASL.AbortOnFailure {
+		net.Sublogger.Warnln("Disconnecting because SASL authentication failed")
+		net.abort("SASL authentication failed: " + reason)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/ircv3"
+)
+
+func init() {
+	ircv3.Register(ircv3.Capability{
+		Name: "sts",
+		// The sts capability is only advertised, never requested
+		Want:       func(interfaces.Network, string) bool { return false },
+		Advertised: stsAdvertised,
+	})
+}
+
+// connAddress is the address of an IRC connection
+type connAddress struct {
+	IP   string
+	Port uint16
+	SSL  bool
+	// STS is true if the connection was upgraded to TLS because of an STS policy
+	STS bool
+}
+
+// stsUpgrade is the TLS port a server told to use when it was connected to
+// over plaintext. It's used until a TLS connection to the server has been
+// registered, as STS forbids falling back to plaintext.
+type stsUpgrade struct {
+	Host string
+	Port uint16
+}
+
+// address gets the address to connect to for the given server. Plaintext
+// connections to the main server are upgraded to TLS if the network has a
+// valid STS policy, and connections to a server that has told to upgrade are
+// upgraded until the upgrade succeeds.
+func (net *netImpl) address(server interfaces.Server) connAddress {
+	// IPv6 addresses may be written with or without brackets
+	addr := connAddress{IP: strings.Trim(server.IP, "[]"), Port: server.Port, SSL: server.SSL}
+	if addr.SSL {
+		return addr
+	} else if net.STSUpgrade != nil && net.STSUpgrade.Host == addr.IP {
+		addr.Port, addr.SSL, addr.STS = net.STSUpgrade.Port, true, true
+	} else if addr.IP == net.IP && net.STS != nil && net.STS.Expires > time.Now().Unix() {
+		addr.Port, addr.SSL, addr.STS = net.STS.Port, true, true
+	}
+	return addr
+}
+
+// stsUpgraded forgets the upgrade after a TLS connection to the server that
+// told to upgrade has been registered
+func (net *netImpl) stsUpgraded() {
+	if net.STSUpgrade != nil && net.Address.SSL && net.Address.IP == net.STSUpgrade.Host {
+		net.STSUpgrade = nil
+	}
+}
+
+// stsAdvertised handles the STS policy of the server. Over TLS the policy is
+// stored and over plaintext the connection is upgraded to TLS immediately.
+func stsAdvertised(inet interfaces.Network, value string) {
+	net, ok := inet.(*netImpl)
+	if !ok {
+		return
+	}
+
+	policy := make(map[string]string)
+	for _, key := range strings.Split(value, ",") {
+		parts := strings.SplitN(key, "=", 2)
+		if len(parts) == 2 {
+			policy[parts[0]] = parts[1]
+		} else {
+			policy[parts[0]] = ""
+		}
+	}
+
+	if net.Address.SSL {
+		if net.Address.IP != net.IP {
+			// The policy is only stored for the main server
+			return
+		}
+		duration, err := strconv.ParseInt(policy["duration"], 10, 64)
+		if err != nil {
+			return
+		} else if duration == 0 {
+			net.Sublogger.Infoln("Removing STS policy")
+			net.STS = nil
+		} else {
+			net.STS = &interfaces.STSPolicy{Port: net.Address.Port, Expires: time.Now().Unix() + duration}
+		}
+		net.Owner.HostConf.Autosave()
+		return
+	}
+
+	port, err := strconv.ParseUint(policy["port"], 10, 16)
+	if err != nil || port == 0 {
+		return
+	}
+	net.Sublogger.Infof("Upgrading connection to TLS on port %d as required by the STS policy\n", port)
+	net.STSUpgrade = &stsUpgrade{Host: net.Address.IP, Port: uint16(port)}
+	go net.reconnectNow()
+}
@@ -0,0 +1,126 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	msg "github.com/sorcix/irc"
+	"maunium.net/go/mauirc-server/util/ircv3"
+)
+
+func init() {
+	ircv3.Register(ircv3.Capability{Name: "message-tags"})
+	ircv3.Register(ircv3.Capability{Name: "server-time"})
+}
+
+// maxQueuedTags is the maximum number of tagged lines waiting to be handled
+const maxQueuedTags = 1000
+
+// taggedLine contains the tags of a received line and the key of the line
+type taggedLine struct {
+	key  string
+	tags map[string]string
+}
+
+// tagQueue passes the tags of received lines from the relay to the handlers.
+// The relay removes the tags before the IRC library parses the lines, and the
+// handlers find the tags of their line by its contents.
+type tagQueue struct {
+	lock  sync.Mutex
+	lines []taggedLine
+	// current is the line whose tags were found last. All the handlers of a line get the same tags.
+	current     *msg.Message
+	currentTags map[string]string
+}
+
+// lineKey identifies a line by its source and parameters, which the IRC library doesn't change
+func lineKey(evt *msg.Message) string {
+	var key = strings.Join(evt.Params, " ")
+	if evt.Prefix != nil {
+		key = evt.Name + "!" + evt.User + "@" + evt.Host + " " + key
+	}
+	return key
+}
+
+// filter removes the tags from the given line and queues them for the handlers of the line
+func (queue *tagQueue) filter(line string) string {
+	tags, line := ircv3.SplitTags(line)
+	if tags == nil {
+		return line
+	}
+	evt := msg.ParseMessage(line)
+	if evt == nil {
+		return line
+	}
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.lines) >= maxQueuedTags {
+		queue.lines = queue.lines[1:]
+	}
+	queue.lines = append(queue.lines, taggedLine{key: lineKey(evt), tags: tags})
+	return line
+}
+
+// reset forgets the tags of the lines of the previous connection
+func (queue *tagQueue) reset() {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	queue.lines = nil
+	queue.current, queue.currentTags = nil, nil
+}
+
+// get finds the tags of the given line. The lines before it in the queue
+// didn't have handlers that wanted their tags, so they're dropped.
+func (queue *tagQueue) get(evt *msg.Message) map[string]string {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if evt == queue.current {
+		return queue.currentTags
+	}
+
+	key := lineKey(evt)
+	for i, line := range queue.lines {
+		if line.key == key {
+			queue.lines = queue.lines[i+1:]
+			queue.current, queue.currentTags = evt, line.tags
+			return line.tags
+		}
+	}
+	return nil
+}
+
+// receiveLine stores a message caused by the given line with the tags of the
+// line. The time tag is used as the timestamp if present.
+func (net *netImpl) receiveLine(evt *msg.Message, channel, sender, command, message string) {
+	var tags map[string]string
+	if lineTags := net.Tags.get(evt); len(lineTags) > 0 {
+		tags = make(map[string]string, len(lineTags))
+		for name, value := range lineTags {
+			tags[name] = value
+		}
+	}
+	timestamp, ok := ircv3.ServerTime(tags)
+	if !ok {
+		timestamp = time.Now()
+	}
+	net.receive(channel, sender, command, message, timestamp, tags)
+}
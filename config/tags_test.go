@@ -0,0 +1,56 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+
+	msg "github.com/sorcix/irc"
+)
+
+func TestTagQueue(t *testing.T) {
+	first := &msg.Message{Prefix: &msg.Prefix{Name: "a", User: "b", Host: "c"}, Command: msg.PRIVMSG, Params: []string{"#chan"}, Trailing: "hi"}
+	second := &msg.Message{Prefix: first.Prefix, Command: msg.PRIVMSG, Params: []string{"#chan"}, Trailing: "hi again"}
+	other := &msg.Message{Prefix: first.Prefix, Command: msg.QUIT, Trailing: "bye"}
+
+	var queue tagQueue
+	queue.lines = []taggedLine{
+		{key: lineKey(other), tags: map[string]string{"time": "0"}},
+		{key: lineKey(first), tags: map[string]string{"time": "1"}},
+		{key: lineKey(second), tags: map[string]string{"time": "2"}},
+	}
+
+	if tags := queue.get(first); tags["time"] != "1" {
+		t.Errorf("Expected the tags of the first line, got %v", tags)
+	}
+	// Another handler of the same line gets the same tags
+	if tags := queue.get(first); tags["time"] != "1" {
+		t.Errorf("Expected the tags of the first line again, got %v", tags)
+	}
+	if tags := queue.get(second); tags["time"] != "2" {
+		t.Errorf("Expected the tags of the second line, got %v", tags)
+	}
+	// The skipped line was dropped
+	if tags := queue.get(other); tags != nil {
+		t.Errorf("Expected no tags for a dropped line, got %v", tags)
+	}
+
+	queue.reset()
+	if len(queue.lines) != 0 || queue.current != nil {
+		t.Error("Expected reset to clear the queue")
+	}
+}
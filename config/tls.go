@@ -0,0 +1,210 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config contains configurations
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/clientcert"
+)
+
+// certPath gets the path of the client certificate of the network. The
+// certificates aren't in the script directory of the network, because all
+// the files there are loaded as scripts.
+func (net *netImpl) certPath() string {
+	return filepath.Join(net.Owner.HostConf.Path, net.Owner.Email, ".certs", net.Name+".pem")
+}
+
+// loadClientCert loads the client certificate of the network. Both return values are nil if there's no certificate.
+func (net *netImpl) loadClientCert() (*tls.Certificate, error) {
+	data, err := ioutil.ReadFile(net.certPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cert, err := clientcert.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (net *netImpl) GetClientCert() (*clientcert.Info, error) {
+	cert, err := net.loadClientCert()
+	if err != nil || cert == nil {
+		return nil, err
+	}
+	info := clientcert.GetInfo(*cert)
+	return &info, nil
+}
+
+// SetClientCert replaces the client certificate. The new certificate is used when the network is connected the next time.
+func (net *netImpl) SetClientCert(data []byte) (*clientcert.Info, error) {
+	cert, err := clientcert.Load(data)
+	if err != nil {
+		return nil, err
+	}
+
+	path := net.certPath()
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info := clientcert.GetInfo(cert)
+	return &info, nil
+}
+
+func (net *netImpl) GenerateClientCert(keyType string) (*clientcert.Info, error) {
+	data, err := clientcert.Generate(net.Nick, keyType)
+	if err != nil {
+		return nil, err
+	}
+	return net.SetClientCert(data)
+}
+
+func (net *netImpl) RemoveClientCert() error {
+	err := os.Remove(net.certPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// renameClientCert moves the client certificate after the network has been renamed
+func (net *netImpl) renameClientCert(oldName string) {
+	oldPath := filepath.Join(filepath.Dir(net.certPath()), oldName+".pem")
+	err := os.Rename(oldPath, net.certPath())
+	if err != nil && !os.IsNotExist(err) {
+		net.Sublogger.Warnln("Failed to rename client certificate:", err)
+	}
+}
+
+func (net *netImpl) GetTLS() *interfaces.TLS {
+	return net.TLS
+}
+
+func (net *netImpl) SetTLS(settings *interfaces.TLS) {
+	net.TLS = settings
+	net.Owner.HostConf.Autosave()
+}
+
+// tlsConfig creates the TLS config for connecting to the given address with
+// the client certificate and the TLS settings of the network
+func (net *netImpl) tlsConfig(addr connAddress) *tls.Config {
+	// The certificate is verified manually to allow pinning and to report the details of invalid certificates
+	config := &tls.Config{ServerName: addr.IP, InsecureSkipVerify: true}
+	// STS doesn't allow skipping the verification of upgraded connections
+	config.VerifyPeerCertificate = net.verifier(addr.IP, addr.STS)
+
+	cert, err := net.loadClientCert()
+	if err != nil {
+		net.Sublogger.Warnln("Failed to load client certificate:", err)
+	} else if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config
+}
+
+// verifier creates a function that verifies the certificate of the server.
+// Pinned certificates are accepted without further checks. Other certificates
+// must be valid for the host and signed by a system CA or the custom CA of
+// the network. The insecure setting is ignored if strict is true.
+func (net *netImpl) verifier(host string, strict bool) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	settings := net.TLS
+	if settings == nil {
+		settings = &interfaces.TLS{}
+	}
+
+	var roots *x509.CertPool
+	if len(settings.CA) > 0 {
+		var err error
+		roots, err = x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM([]byte(settings.CA)) {
+			net.Sublogger.Warnln("The custom CA of the network doesn't contain any valid certificates")
+		}
+	}
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("The server didn't send a certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			var err error
+			certs[i], err = x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+		}
+
+		if (settings.Insecure && !strict) || settings.IsPinned(rawCerts[0]) {
+			return nil
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates})
+		if err != nil {
+			net.reportTLSFailure(err, certs[0])
+		}
+		return err
+	}
+}
+
+// reportTLSFailure sends the details of a certificate that failed verification to the clients
+func (net *netImpl) reportTLSFailure(err error, cert *x509.Certificate) {
+	net.Sublogger.Warnln("Failed to verify server certificate:", err)
+	sum256 := sha256.Sum256(cert.Raw)
+	sum512 := sha512.Sum512(cert.Raw)
+	net.Owner.SendMessage(messages.Container{Type: messages.MsgNetEvent, Object: messages.NetEvent{
+		Network: net.Name,
+		Type:    messages.NetEventTLSFailed,
+		Message: err.Error(),
+		Certificate: &messages.CertificateInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			DNSNames:  cert.DNSNames,
+			NotBefore: cert.NotBefore.Unix(),
+			NotAfter:  cert.NotAfter.Unix(),
+			SHA256:    hex.EncodeToString(sum256[:]),
+			SHA512:    hex.EncodeToString(sum512[:]),
+		},
+	}})
+}
@@ -21,26 +21,33 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"strings"
+	"sync"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
 	"golang.org/x/crypto/bcrypt"
 	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
 	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/hub"
 )
 
 type userImpl struct {
-	Networks      netListImpl             `yaml:"networks" json:"networks"`
-	Email         string                  `yaml:"email" json:"email"`
-	Password      string                  `yaml:"password" json:"password"`
-	AuthTokens    []authToken             `yaml:"authtokens,omitempty" json:"authtokens,omitempty"`
-	PasswordReset *authToken              `yaml:"passwordreset,omitempty" json:"passwordreset,omitempty"`
-	EmailVerify   *authToken              `yaml:"emailverify,omitempty" json:"emailverify,omitempty"`
-	NewMessages   chan messages.Container `yaml:"-" json:"-"`
-	GlobalScripts []interfaces.Script     `yaml:"-" json:"-"`
-	Settings      interface{}             `yaml:"settings,omitempty" json:"settings,omitempty"`
-	HostConf      *configImpl             `yaml:"-" json:"-"`
+	Networks      netListImpl           `yaml:"networks" json:"networks"`
+	Email         string                `yaml:"email" json:"email"`
+	Password      string                `yaml:"password" json:"password"`
+	AuthTokens    []authToken           `yaml:"authtokens,omitempty" json:"authtokens,omitempty"`
+	PasswordReset *authToken            `yaml:"passwordreset,omitempty" json:"passwordreset,omitempty"`
+	EmailVerify   *authToken            `yaml:"emailverify,omitempty" json:"emailverify,omitempty"`
+	Hub           *hub.Hub              `yaml:"-" json:"-"`
+	GlobalScripts []interfaces.Script   `yaml:"-" json:"-"`
+	Settings      interface{}           `yaml:"settings,omitempty" json:"settings,omitempty"`
+	Retention     *interfaces.Retention `yaml:"retention,omitempty" json:"retention,omitempty"`
+	Bind          string                `yaml:"bind,omitempty" json:"bind,omitempty"`
+	HostConf      *configImpl           `yaml:"-" json:"-"`
+	// retentionLock guards Retention
+	retentionLock sync.RWMutex
 }
 
 type authToken struct {
@@ -87,19 +94,34 @@ func (user *userImpl) InitNetworks() {
 		if network.Owner != nil {
 			continue
 		}
-		network.ChannelInfo = make(map[string]*chanDataImpl)
+		network.ChannelInfo = newChannelDataList()
 		network.Owner = user
 		network.Open()
 		network.LoadScripts(user.HostConf.Path)
 	}
 }
 
-func (user *userImpl) SendNetworkData(net interfaces.Network) {
-	user.SendMessage(messages.Container{Type: messages.MsgNetData, Object: net.GetNetData()})
+func (user *userImpl) SendNetworkData(net interfaces.Network, send func(messages.Container)) {
+	if send == nil {
+		send = user.SendMessage
+	}
+
+	send(messages.Container{Type: messages.MsgNetData, Object: net.GetNetData()})
+	net.GetActiveChannels().ForEach(func(chd interfaces.ChannelData) {
+		send(messages.Container{Type: messages.MsgChanData, Object: chd})
+	})
+	send(messages.Container{Type: messages.MsgChanList, Object: messages.ChanList{Network: net.GetName(), List: net.GetAllChannels()}})
+
+	var channels []string
 	net.GetActiveChannels().ForEach(func(chd interfaces.ChannelData) {
-		user.SendMessage(messages.Container{Type: messages.MsgChanData, Object: chd})
+		channels = append(channels, chd.GetName())
 	})
-	user.SendMessage(messages.Container{Type: messages.MsgChanList, Object: messages.ChanList{Network: net.GetName(), List: net.GetAllChannels()}})
+	unread, err := database.Unread(user.Email, net.GetName(), net.GetNetData().Nick, channels)
+	if err != nil {
+		log.Warnf("<%s> Failed to get unread counts of %s: %s\n", user.Email, net.GetName(), err)
+		return
+	}
+	send(messages.Container{Type: messages.MsgUnread, Object: messages.UnreadData{Network: net.GetName(), Channels: unread}})
 }
 
 // GetNetwork gets the network with the given name
@@ -226,14 +248,19 @@ func (user *userImpl) GetNameFromEmail() string {
 }
 
 func (user *userImpl) SendMessage(msg messages.Container) {
-	if len(user.NewMessages) >= MessageBufferSize {
-		<-user.NewMessages
-	}
-	user.NewMessages <- msg
+	user.Hub.Publish(msg)
+}
+
+func (user *userImpl) Subscribe(name string) *hub.Subscription {
+	return user.Hub.Subscribe(name)
+}
+
+func (user *userImpl) GetHub() *hub.Hub {
+	return user.Hub
 }
 
-func (user *userImpl) GetMessageChan() chan messages.Container {
-	return user.NewMessages
+func (user *userImpl) GetClients() []hub.Stats {
+	return user.Hub.Stats()
 }
 
 func (user *userImpl) GetGlobalScripts() []interfaces.Script {
@@ -270,3 +297,20 @@ func (user *userImpl) GetSettings() interface{} {
 func (user *userImpl) SetSettings(val interface{}) {
 	user.Settings = val
 }
+
+func (user *userImpl) GetRetention() *interfaces.Retention {
+	user.retentionLock.RLock()
+	defer user.retentionLock.RUnlock()
+	return user.Retention
+}
+
+func (user *userImpl) SetRetention(r *interfaces.Retention) {
+	user.retentionLock.Lock()
+	user.Retention = r
+	user.retentionLock.Unlock()
+	user.HostConf.Autosave()
+}
+
+func (user *userImpl) GetBind() string {
+	return user.Bind
+}
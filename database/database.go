@@ -18,146 +18,211 @@
 package database
 
 import (
-	"database/sql"
-	"encoding/json"
-	"errors"
+	"fmt"
 
 	"maunium.net/go/mauirc-server/common/messages"
 )
 
-var db *sql.DB
+// Store is a message storage backend
+type Store interface {
+	// Close the connection to the backend
+	Close() error
+
+	// Migrate applies all pending schema migrations and returns the ones that were applied
+	Migrate() ([]string, error)
+	// PendingMigrations returns the schema migrations that haven't been applied yet
+	PendingMigrations() ([]string, error)
+
+	// Insert a message and return its ID
+	Insert(email string, msg messages.Message) (int64, error)
+	// InsertBatch inserts all the given messages in a single transaction and
+	// sets their IDs. If an error is returned, none of the messages were inserted.
+	InsertBatch(batch []*Insertion) error
+	// GetHistory gets the last n messages
+	GetHistory(email string, n int) ([]messages.Message, error)
+	// GetNetworkHistory gets the last n messages on the given network
+	GetNetworkHistory(email, network string, n int) ([]messages.Message, error)
+	// GetChannelHistory gets the last n messages on the given channel
+	GetChannelHistory(email, network, channel string, n int) ([]messages.Message, error)
+	// QueryHistory gets a page of messages matching the given query
+	QueryHistory(email string, query HistoryQuery) ([]messages.Message, error)
+	// Search gets the messages that best match the given full-text search
+	Search(email string, query messages.Search) ([]messages.SearchResult, error)
+
+	// DeleteMessage deletes the message with the given ID
+	DeleteMessage(email string, id int64) error
+	// ClearChannel clears all the messages in the given channel
+	ClearChannel(email, network, channel string) error
+	// ClearNetwork clears all the messages in the given network
+	ClearNetwork(email, network string) error
+	// ClearUser clears all messages owned by the given user
+	ClearUser(email string) error
+
+	// Channels gets the names of all channels the given user has history in, grouped by network
+	Channels(email string) (map[string][]string, error)
+	// Prune deletes the messages in the given channel that were sent before the
+	// given timestamp in milliseconds and all but the newest keep messages. Zero
+	// values are ignored.
+	Prune(email, network, channel string, before int64, keep int) (int64, error)
+
+	// LastID gets the ID of the newest message of the given user
+	LastID(email string) (int64, error)
+
+	// SetReadMarker sets the ID of the last message the user has read in the
+	// given channel. Read markers only move forward.
+	SetReadMarker(email string, marker messages.ReadMarker) error
+	// Unread gets the read markers and the numbers of unread messages and
+	// highlights of all channels in the given network that have a read marker.
+	// Messages that contain the given nick are highlights. The given channels
+	// that don't have a read marker are reported as read up to their latest
+	// message. Unread never modifies the read markers.
+	Unread(email, network, nick string, channels []string) ([]messages.ChannelUnread, error)
+}
+
+// HistoryQuery selects a page of history. Zero values mean no limit.
+type HistoryQuery struct {
+	// Network and Channel limit the results to the given network or channel.
+	Network string
+	Channel string
+	// Before and After limit the results to messages older or newer than the
+	// messages with the given IDs.
+	Before int64
+	After  int64
+	// Since and Until limit the results to messages sent in the given
+	// timestamp range in milliseconds (both ends inclusive).
+	Since int64
+	Until int64
+	// Limit is the maximum number of messages to return.
+	Limit int
+	// Forward selects the oldest matching messages instead of the newest ones
+	// even if After isn't set.
+	Forward bool
+}
+
+// Ascending tells whether the query pages forwards, i.e. selects the messages
+// right after the After ID rather than the ones right before the Before ID.
+// The results are always sorted newest first.
+func (query HistoryQuery) Ascending() bool {
+	return query.Forward || (query.After > 0 && query.Before <= 0)
+}
 
-// Load the database
-func Load(sqlStr string) error {
+var store Store
+
+// Open a Store of the given type. The type must be one of mysql, postgres or sqlite.
+func Open(typ, dsn string) (Store, error) {
+	switch typ {
+	case "mysql":
+		return openSQL(mysql, dsn)
+	case "postgres":
+		return openSQL(postgres, dsn)
+	case "sqlite":
+		return openSQL(sqlite, dsn)
+	default:
+		return nil, fmt.Errorf("Unknown database type %s", typ)
+	}
+}
+
+// Load the database. Migrate must be called before the database is used.
+func Load(typ, dsn string) error {
 	var err error
-	db, err = sql.Open("mysql", sqlStr)
+	store, err = Open(typ, dsn)
 	if err != nil {
 		return err
-	} else if db == nil {
-		return errors.New("Failed to open SQL connection!")
 	}
+	startWriter()
+	return nil
+}
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS messages (" +
-		"id BIGINT PRIMARY KEY AUTO_INCREMENT," +
-		"email VARCHAR(255) NOT NULL," +
-		"network VARCHAR(255) NOT NULL," +
-		"channel VARCHAR(255) NOT NULL," +
-		"timestamp BIGINT NOT NULL," +
-		"sender VARCHAR(255) NOT NULL," +
-		"command VARCHAR(255) NOT NULL," +
-		"message TEXT NOT NULL," +
-		"ownmessage TINYINT(1) NOT NULL," +
-		"preview TEXT" +
-		") DEFAULT CHARSET=utf8;")
-	return err
-}
-
-// Close the database connection
+// Close the database connection after writing all queued messages
 func Close() {
-	db.Close()
+	stopWriter()
+	store.Close()
+}
+
+// Migrate applies all pending schema migrations
+func Migrate() ([]string, error) {
+	return store.Migrate()
+}
+
+// PendingMigrations returns the schema migrations that haven't been applied yet
+func PendingMigrations() ([]string, error) {
+	return store.PendingMigrations()
 }
 
 // GetHistory gets the last n messages
 func GetHistory(email string, n int) ([]messages.Message, error) {
-	results, err := db.Query("SELECT id, network, channel, timestamp, sender, command, message, ownmessage, preview FROM messages WHERE email=? ORDER BY id DESC LIMIT ?", email, n)
-	if err != nil {
-		return nil, err
-	}
-	return scanMessages(results)
+	return store.GetHistory(email, n)
 }
 
 // GetNetworkHistory gets the last n messages on the given network
 func GetNetworkHistory(email, network string, n int) ([]messages.Message, error) {
-	results, err := db.Query("SELECT id, network, channel, timestamp, sender, command, message, ownmessage, preview FROM messages WHERE email=? AND network=? ORDER BY id DESC LIMIT ?", email, network, n)
-	if err != nil {
-		return nil, err
-	}
-	return scanMessages(results)
+	return store.GetNetworkHistory(email, network, n)
 }
 
 // GetChannelHistory gets the last n messages on the given channel
 func GetChannelHistory(email, network, channel string, n int) ([]messages.Message, error) {
-	results, err := db.Query("SELECT id, network, channel, timestamp, sender, command, message, ownmessage, preview FROM messages WHERE email=? AND network=? AND channel=? ORDER BY id DESC LIMIT ?", email, network, channel, n)
-	if err != nil {
-		return nil, err
-	}
-	return scanMessages(results)
-}
-
-func scanMessages(results *sql.Rows) ([]messages.Message, error) {
-	var msgs []messages.Message
-	for results.Next() {
-		if results.Err() != nil {
-			return msgs, results.Err()
-		}
-
-		var network, channel, sender, command, message, previewStr string
-		var ownmessage bool
-		var timestamp, id int64
-
-		results.Scan(&id, &network, &channel, &timestamp, &sender, &command, &message, &ownmessage, &previewStr)
-
-		var pw = &messages.Preview{}
-		if len(previewStr) > 0 {
-			json.Unmarshal([]byte(previewStr), pw)
-		} else {
-			pw = nil
-		}
-
-		msgs = append(msgs, messages.Message{
-			ID:        id,
-			Network:   network,
-			Channel:   channel,
-			Timestamp: timestamp,
-			Sender:    sender,
-			Command:   command,
-			Message:   message,
-			OwnMsg:    ownmessage,
-			Preview:   pw,
-		})
-	}
-	return msgs, nil
+	return store.GetChannelHistory(email, network, channel, n)
+}
+
+// QueryHistory gets a page of messages matching the given query
+func QueryHistory(email string, query HistoryQuery) ([]messages.Message, error) {
+	return store.QueryHistory(email, query)
 }
 
 // DeleteMessage deletes the message with the given ID
 func DeleteMessage(email string, id int64) error {
-	_, err := db.Exec("DELETE FROM messages WHERE email=? AND id=?;", email, id)
-	return err
+	return store.DeleteMessage(email, id)
 }
 
 // ClearChannel clears all the messages in the given channel.
 func ClearChannel(email, network, channel string) error {
-	_, err := db.Exec("DELETE FROM messages WHERE email=? AND network=? AND channel=?;", email, network, channel)
-	return err
+	return store.ClearChannel(email, network, channel)
 }
 
 // ClearNetwork clears all the messages in the channels that are in the given network.
 func ClearNetwork(email, network string) error {
-	_, err := db.Exec("DELETE FROM messages WHERE email=? AND network=?;", email, network)
-	return err
+	return store.ClearNetwork(email, network)
 }
 
 // ClearUser clears all messages owned by the given user.
 func ClearUser(email string) error {
-	_, err := db.Exec("DELETE FROM messages WHERE email=?;", email)
-	return err
-}
-
-// Insert a message into the database
-func Insert(email string, msg messages.Message) int64 {
-	var preview = ""
-	if msg.Preview != nil {
-		data, err := json.Marshal(msg.Preview)
-		if err == nil {
-			preview = string(data)
-		}
-	}
-	db.Exec("INSERT INTO messages (email, network, channel, timestamp, sender, command, message, ownmessage, preview) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
-		email, msg.Network, msg.Channel, msg.Timestamp, msg.Sender, msg.Command, msg.Message, msg.OwnMsg, preview)
-
-	result := db.QueryRow("SELECT id FROM messages WHERE email=? AND network=? AND channel=? AND timestamp=? AND sender=? AND command=? AND message=? AND ownmessage=?;",
-		email, msg.Network, msg.Channel, msg.Timestamp, msg.Sender, msg.Command, msg.Message, msg.OwnMsg)
-	var id int64
-	result.Scan(&id)
-	return id
+	return store.ClearUser(email)
+}
+
+// Channels gets the names of all channels the given user has history in, grouped by network
+func Channels(email string) (map[string][]string, error) {
+	return store.Channels(email)
+}
+
+// Prune deletes old messages from the given channel and returns the number of messages deleted
+func Prune(email, network, channel string, before int64, keep int) (int64, error) {
+	return store.Prune(email, network, channel, before, keep)
+}
+
+// LastID gets the ID of the newest message of the given user
+func LastID(email string) (int64, error) {
+	return store.LastID(email)
+}
+
+// SetReadMarker moves the read marker of the given channel forward
+func SetReadMarker(email string, marker messages.ReadMarker) error {
+	return store.SetReadMarker(email, marker)
+}
+
+// Unread gets the read markers and unread counts of the channels in the given
+// network. The given channels are reported as read up to their latest message
+// if they don't have a read marker.
+func Unread(email, network, nick string, channels []string) ([]messages.ChannelUnread, error) {
+	return store.Unread(email, network, nick, channels)
+}
+
+// InsertBatch inserts all the given messages immediately in a single transaction
+func InsertBatch(batch []*Insertion) error {
+	return store.InsertBatch(batch)
+}
+
+// Insert a message into the database immediately. Use QueueInsert to avoid
+// blocking the caller.
+func Insert(email string, msg messages.Message) (int64, error) {
+	return store.Insert(email, msg)
 }
@@ -0,0 +1,194 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a single step in the database schema history
+type migration struct {
+	Description string
+	// Up contains the statements to run for each dialect. The statements under
+	// the empty key are used for dialects that don't have their own entry.
+	Up map[string][]string
+}
+
+// String returns the version and description of the migration at the given index
+func (m migration) String(index int) string {
+	return fmt.Sprintf("%d: %s", index+1, m.Description)
+}
+
+func (m migration) statements(dialect string) []string {
+	stmts, ok := m.Up[dialect]
+	if !ok {
+		stmts = m.Up[""]
+	}
+	return stmts
+}
+
+// migrations contains all the schema migrations in order. The version of the
+// schema is the number of migrations that have been applied, so existing
+// entries must never be changed or reordered, only appended to.
+var migrations = []migration{
+	{"Create messages table", map[string][]string{
+		mysql.Name:    {mysql.CreateMessages},
+		postgres.Name: {postgres.CreateMessages},
+		sqlite.Name:   {sqlite.CreateMessages},
+	}},
+	{"Add history index", map[string][]string{
+		// MySQL can't index the full length of three utf8 VARCHAR(255) columns
+		mysql.Name: {"CREATE INDEX messages_history ON messages (email(64), network(64), channel(64), id);"},
+		"":         {"CREATE INDEX messages_history ON messages (email, network, channel, id);"},
+	}},
+	{"Add full-text search index", map[string][]string{
+		mysql.Name:    {"CREATE FULLTEXT INDEX messages_fulltext ON messages (message);"},
+		postgres.Name: {"CREATE INDEX messages_fulltext ON messages USING GIN (to_tsvector('simple', message));"},
+		sqlite.Name: {
+			"CREATE VIRTUAL TABLE messages_fts USING fts4(content=\"messages\", message);",
+			"INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');",
+			"CREATE TRIGGER messages_fts_bu BEFORE UPDATE ON messages BEGIN DELETE FROM messages_fts WHERE docid=old.id; END;",
+			"CREATE TRIGGER messages_fts_bd BEFORE DELETE ON messages BEGIN DELETE FROM messages_fts WHERE docid=old.id; END;",
+			"CREATE TRIGGER messages_fts_au AFTER UPDATE ON messages BEGIN INSERT INTO messages_fts(docid, message) VALUES (new.id, new.message); END;",
+			"CREATE TRIGGER messages_fts_ai AFTER INSERT ON messages BEGIN INSERT INTO messages_fts(docid, message) VALUES (new.id, new.message); END;",
+		},
+	}},
+	{"Create read markers table", map[string][]string{
+		mysql.Name: {"CREATE TABLE read_markers (" +
+			"email VARCHAR(255) NOT NULL," +
+			"network VARCHAR(255) NOT NULL," +
+			"channel VARCHAR(255) NOT NULL," +
+			"message_id BIGINT NOT NULL," +
+			"PRIMARY KEY (email(64), network(64), channel(64))" +
+			") DEFAULT CHARSET=utf8;"},
+		"": {"CREATE TABLE read_markers (" +
+			"email VARCHAR(255) NOT NULL," +
+			"network VARCHAR(255) NOT NULL," +
+			"channel VARCHAR(255) NOT NULL," +
+			"message_id BIGINT NOT NULL," +
+			"PRIMARY KEY (email, network, channel)" +
+			");"},
+	}},
+	{"Store message timestamps in milliseconds", map[string][]string{
+		"": {"UPDATE messages SET timestamp=timestamp*1000;"},
+	}},
+	{"Add message tags column", map[string][]string{
+		"": {"ALTER TABLE messages ADD COLUMN tags TEXT;"},
+	}},
+	{"Only reindex full-text search on message updates", map[string][]string{
+		// Only SQLite maintains the index with triggers
+		sqlite.Name: {
+			"DROP TRIGGER messages_fts_bu;",
+			"DROP TRIGGER messages_fts_au;",
+			"CREATE TRIGGER messages_fts_bu BEFORE UPDATE OF message ON messages BEGIN DELETE FROM messages_fts WHERE docid=old.id; END;",
+			"CREATE TRIGGER messages_fts_au AFTER UPDATE OF message ON messages BEGIN INSERT INTO messages_fts(docid, message) VALUES (new.id, new.message); END;",
+		},
+	}},
+}
+
+const createSchemaVersion = "CREATE TABLE IF NOT EXISTS schema_version (" +
+	"version INTEGER PRIMARY KEY," +
+	"description VARCHAR(255) NOT NULL," +
+	"applied BIGINT NOT NULL" +
+	");"
+
+// version gets the current schema version. If create is false, the version
+// table isn't created and a database without one is at version 0.
+func (store *sqlStore) version(create bool) (int, error) {
+	if create {
+		_, err := store.db.Exec(createSchemaVersion)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var tables int
+		err := store.queryRow(store.dialect.TableExists, "schema_version").Scan(&tables)
+		if err != nil {
+			return 0, err
+		} else if tables == 0 {
+			return 0, nil
+		}
+	}
+
+	var version sql.NullInt64
+	err := store.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+func (store *sqlStore) PendingMigrations() ([]string, error) {
+	version, err := store.version(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for i := version; i < len(migrations); i++ {
+		pending = append(pending, migrations[i].String(i))
+	}
+	return pending, nil
+}
+
+func (store *sqlStore) Migrate() ([]string, error) {
+	version, err := store.version(true)
+	if err != nil {
+		return nil, err
+	} else if version > len(migrations) {
+		return nil, fmt.Errorf("Database schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	var applied []string
+	for i := version; i < len(migrations); i++ {
+		err = store.migrate(i)
+		if err != nil {
+			return applied, fmt.Errorf("Failed to apply migration %s: %s", migrations[i].String(i), err)
+		}
+		applied = append(applied, migrations[i].String(i))
+	}
+	return applied, nil
+}
+
+// migrate applies the migration at the given index in a single transaction.
+// Note that MySQL commits DDL statements implicitly, so a failed migration
+// may be partially applied there.
+func (store *sqlStore) migrate(index int) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range migrations[index].statements(store.dialect.Name) {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(store.rebind("INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?);"),
+		index+1, migrations[index].Description, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
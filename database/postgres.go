@@ -0,0 +1,49 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	// PostgreSQL driver
+	_ "github.com/lib/pq"
+)
+
+var postgres = dialect{
+	Name:           "postgres",
+	Driver:         "postgres",
+	NumberedParams: true,
+	Returning:      true,
+	CreateMessages: "CREATE TABLE IF NOT EXISTS messages (" +
+		"id BIGSERIAL PRIMARY KEY," +
+		"email VARCHAR(255) NOT NULL," +
+		"network VARCHAR(255) NOT NULL," +
+		"channel VARCHAR(255) NOT NULL," +
+		"timestamp BIGINT NOT NULL," +
+		"sender VARCHAR(255) NOT NULL," +
+		"command VARCHAR(255) NOT NULL," +
+		"message TEXT NOT NULL," +
+		"ownmessage BOOLEAN NOT NULL," +
+		"preview TEXT" +
+		");",
+	TableExists: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=?",
+	UpsertReadMarker: "INSERT INTO read_markers (email, network, channel, message_id) VALUES (?, ?, ?, ?) " +
+		"ON CONFLICT (email, network, channel) DO UPDATE SET message_id=excluded.message_id " +
+		"WHERE read_markers.message_id < excluded.message_id;",
+	SearchFrom:  "messages",
+	SearchMatch: "to_tsvector('simple', messages.message) @@ plainto_tsquery('simple', ?)",
+	SearchRank:  "ts_rank(to_tsvector('simple', messages.message), plainto_tsquery('simple', ?))",
+}
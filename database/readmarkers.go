@@ -0,0 +1,118 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+func (store *sqlStore) SetReadMarker(email string, marker messages.ReadMarker) error {
+	_, err := store.exec(store.dialect.UpsertReadMarker, email, marker.Network, marker.Channel, marker.ID)
+	return err
+}
+
+// likeEscaper escapes the LIKE wildcards using ! as the escape character,
+// which unlike the backslash means the same thing in all supported databases.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (store *sqlStore) Unread(email, network, nick string, channelNames []string) ([]messages.ChannelUnread, error) {
+	var channels = make(map[string]*messages.ChannelUnread)
+	get := func(channel string) *messages.ChannelUnread {
+		unread, ok := channels[channel]
+		if !ok {
+			unread = &messages.ChannelUnread{Channel: channel}
+			channels[channel] = unread
+		}
+		return unread
+	}
+
+	results, err := store.query("SELECT channel, message_id FROM read_markers WHERE email=? AND network=?", email, network)
+	if err != nil {
+		return nil, err
+	}
+	for results.Next() {
+		var channel string
+		var id int64
+		if err = results.Scan(&channel, &id); err != nil {
+			results.Close()
+			return nil, err
+		}
+		get(channel).ReadMarker = id
+	}
+	results.Close()
+
+	// Channels without a marker are reported as read up to their latest
+	// message, so their whole history doesn't have to be counted
+	for _, channel := range channelNames {
+		if _, ok := channels[channel]; ok {
+			continue
+		}
+		var latest sql.NullInt64
+		err = store.queryRow("SELECT MAX(id) FROM messages WHERE email=? AND network=? AND channel=?", email, network, channel).Scan(&latest)
+		if err != nil {
+			return nil, err
+		} else if !latest.Valid {
+			continue
+		}
+		get(channel).ReadMarker = latest.Int64
+	}
+
+	// Messages in private queries are always highlights
+	highlight := "messages.channel=messages.sender"
+	args := []interface{}{}
+	if len(nick) > 0 {
+		highlight += " OR LOWER(messages.message) LIKE ? ESCAPE '!'"
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(nick))+"%")
+	}
+	args = append(args, email, network, false)
+
+	results, err = store.query("SELECT messages.channel, COUNT(*), SUM(CASE WHEN "+highlight+" THEN 1 ELSE 0 END) FROM messages "+
+		"JOIN read_markers ON read_markers.email=messages.email AND read_markers.network=messages.network AND read_markers.channel=messages.channel "+
+		"WHERE messages.email=? AND messages.network=? AND messages.ownmessage=? AND messages.command IN ('privmsg', 'action') "+
+		"AND messages.id > read_markers.message_id GROUP BY messages.channel", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for results.Next() {
+		var channel string
+		var unread, highlights int
+		if err = results.Scan(&channel, &unread, &highlights); err != nil {
+			return nil, err
+		}
+		ch := get(channel)
+		ch.Unread = unread
+		ch.Highlights = highlights
+	}
+	if err = results.Err(); err != nil {
+		return nil, err
+	}
+
+	var list = make([]messages.ChannelUnread, 0, len(channels))
+	for _, unread := range channels {
+		list = append(list, *unread)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Channel < list[j].Channel
+	})
+	return list, nil
+}
@@ -0,0 +1,73 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"database/sql"
+)
+
+func (store *sqlStore) Channels(email string) (map[string][]string, error) {
+	results, err := store.query("SELECT DISTINCT network, channel FROM messages WHERE email=?", email)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var channels = make(map[string][]string)
+	for results.Next() {
+		var network, channel string
+		err = results.Scan(&network, &channel)
+		if err != nil {
+			return nil, err
+		}
+		channels[network] = append(channels[network], channel)
+	}
+	return channels, results.Err()
+}
+
+func (store *sqlStore) Prune(email, network, channel string, before int64, keep int) (deleted int64, err error) {
+	if before > 0 {
+		var result sql.Result
+		result, err = store.exec("DELETE FROM messages WHERE email=? AND network=? AND channel=? AND timestamp<?;", email, network, channel, before)
+		if err != nil {
+			return
+		}
+		deleted, _ = result.RowsAffected()
+	}
+
+	if keep > 0 {
+		// MySQL doesn't allow selecting from the table being deleted from, so find the cutoff ID first.
+		var cutoff int64
+		err = store.queryRow("SELECT id FROM messages WHERE email=? AND network=? AND channel=? ORDER BY id DESC LIMIT 1 OFFSET ?",
+			email, network, channel, keep).Scan(&cutoff)
+		if err == sql.ErrNoRows {
+			return deleted, nil
+		} else if err != nil {
+			return
+		}
+
+		var result sql.Result
+		result, err = store.exec("DELETE FROM messages WHERE email=? AND network=? AND channel=? AND id<=?;", email, network, channel, cutoff)
+		if err != nil {
+			return
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return
+}
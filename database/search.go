@@ -0,0 +1,176 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+// Search limits
+const (
+	DefaultSearchLimit   = 50
+	MaxSearchLimit       = 500
+	DefaultSearchContext = 2
+	MaxSearchContext     = 10
+)
+
+// Search history for messages matching the given full-text query
+func Search(email string, query messages.Search) ([]messages.SearchResult, error) {
+	return store.Search(email, query)
+}
+
+// normalizeSearch applies the default and maximum limits to the given search
+func normalizeSearch(query messages.Search) messages.Search {
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	} else if query.Limit > MaxSearchLimit {
+		query.Limit = MaxSearchLimit
+	}
+	if query.Context < 0 {
+		query.Context = 0
+	} else if query.Context == 0 {
+		query.Context = DefaultSearchContext
+	} else if query.Context > MaxSearchContext {
+		query.Context = MaxSearchContext
+	}
+	return query
+}
+
+func (store *sqlStore) Search(email string, query messages.Search) ([]messages.SearchResult, error) {
+	query = normalizeSearch(query)
+
+	terms := query.Query
+	if store.dialect.SearchTerms != nil {
+		terms = store.dialect.SearchTerms(terms)
+	}
+
+	var args []interface{}
+	for i := strings.Count(store.dialect.SearchRank, "?"); i > 0; i-- {
+		args = append(args, terms)
+	}
+	var conds = []string{store.dialect.SearchMatch, "messages.email=?"}
+	args = append(args, terms, email)
+	if len(query.Network) > 0 {
+		conds = append(conds, "messages.network=?")
+		args = append(args, query.Network)
+	}
+	if len(query.Channel) > 0 {
+		conds = append(conds, "messages.channel=?")
+		args = append(args, query.Channel)
+	}
+	if len(query.Sender) > 0 {
+		conds = append(conds, "messages.sender=?")
+		args = append(args, query.Sender)
+	}
+	if query.Since > 0 {
+		conds = append(conds, "messages.timestamp>=?")
+		args = append(args, query.Since)
+	}
+	if query.Until > 0 {
+		conds = append(conds, "messages.timestamp<=?")
+		args = append(args, query.Until)
+	}
+	args = append(args, query.Limit)
+
+	results, err := store.query("SELECT messages.id, messages.network, messages.channel, messages.timestamp, messages.sender, "+
+		"messages.command, messages.message, messages.ownmessage, messages.preview, messages.tags, "+store.dialect.SearchRank+" AS relevance "+
+		"FROM "+store.dialect.SearchFrom+" WHERE "+strings.Join(conds, " AND ")+" ORDER BY relevance DESC, messages.id DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []messages.SearchResult
+	for results.Next() {
+		var rank float64
+		msg, err := scanMessage(results, &rank)
+		if err != nil {
+			results.Close()
+			return nil, err
+		}
+		matches = append(matches, messages.SearchResult{Message: msg, Rank: rank})
+	}
+	results.Close()
+	if results.Err() != nil {
+		return nil, results.Err()
+	}
+
+	for i := 0; i < len(matches) && query.Context > 0; i += contextBatchSize {
+		end := i + contextBatchSize
+		if end > len(matches) {
+			end = len(matches)
+		}
+		err = store.addContext(email, matches[i:end], query.Context)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+// contextBatchSize is the number of search results whose context is fetched
+// with a single query. Each result needs two subqueries with five parameters,
+// which keeps the query within the default SQLite limits.
+const contextBatchSize = 50
+
+// addContext adds the n messages before and after each match in chronological order
+func (store *sqlStore) addContext(email string, matches []messages.SearchResult, n int) error {
+	var queries []string
+	var args []interface{}
+	for i, match := range matches {
+		for _, dir := range []struct {
+			cond, order string
+			before      int
+		}{{"id<?", "DESC", 1}, {"id>?", "ASC", 0}} {
+			queries = append(queries, fmt.Sprintf("SELECT * FROM (SELECT id, network, channel, timestamp, sender, command, message, ownmessage, preview, tags, "+
+				"%d AS match_index, %d AS before_match FROM messages WHERE email=? AND network=? AND channel=? AND %s ORDER BY id %s LIMIT ?) AS context%d",
+				i, dir.before, dir.cond, dir.order, len(queries)))
+			args = append(args, email, match.Message.Network, match.Message.Channel, match.Message.ID, n)
+		}
+	}
+
+	results, err := store.query(strings.Join(queries, " UNION ALL ")+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for results.Next() {
+		var index, before int
+		msg, err := scanMessage(results, &index, &before)
+		if err != nil {
+			return err
+		} else if index < 0 || index >= len(matches) {
+			continue
+		}
+		if before != 0 {
+			matches[index].Before = append(matches[index].Before, msg)
+		} else {
+			matches[index].After = append(matches[index].After, msg)
+		}
+	}
+	return results.Err()
+}
+
+// reverse the given message list in place
+func reverse(msgs []messages.Message) {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+}
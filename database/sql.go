@@ -0,0 +1,313 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+// dialect contains the things that differ between the supported SQL databases
+type dialect struct {
+	// Name is the name of the dialect in the config
+	Name string
+	// Driver is the name of the database/sql driver
+	Driver string
+	// NumberedParams tells whether the driver wants $1, $2... instead of ?
+	NumberedParams bool
+	// Returning tells whether the IDs of inserted rows must be fetched with
+	// INSERT ... RETURNING instead of sql.Result.LastInsertId()
+	Returning bool
+	// CreateMessages creates the messages table. Only used by the first migration.
+	CreateMessages string
+	// TableExists counts the tables with the name given as the parameter
+	TableExists string
+	// UpsertReadMarker creates or moves forward the read marker with the email,
+	// network, channel and message ID given as the parameters
+	UpsertReadMarker string
+
+	// SearchFrom is the FROM clause of full-text search queries
+	SearchFrom string
+	// SearchMatch is the condition that matches messages to the search query
+	SearchMatch string
+	// SearchRank is the expression that calculates the relevance of a match
+	SearchRank string
+	// SearchTerms converts the search query from the user into the format the
+	// full-text search engine wants. Optional.
+	SearchTerms func(query string) string
+}
+
+// sqlStore is a Store backed by a database/sql connection
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func openSQL(d dialect, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(d.Driver, dsn)
+	if err != nil {
+		return nil, err
+	} else if db == nil {
+		return nil, errors.New("Failed to open SQL connection!")
+	}
+
+	return &sqlStore{db: db, dialect: d}, nil
+}
+
+// rebind converts the ? placeholders in the given query to the format the dialect uses
+func (store *sqlStore) rebind(query string) string {
+	if !store.dialect.NumberedParams {
+		return query
+	}
+	var buf bytes.Buffer
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteRune('$')
+			buf.WriteString(strconv.Itoa(n))
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func (store *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return store.db.Exec(store.rebind(query), args...)
+}
+
+func (store *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return store.db.Query(store.rebind(query), args...)
+}
+
+func (store *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return store.db.QueryRow(store.rebind(query), args...)
+}
+
+func (store *sqlStore) Close() error {
+	return store.db.Close()
+}
+
+func (store *sqlStore) GetHistory(email string, n int) ([]messages.Message, error) {
+	return store.QueryHistory(email, HistoryQuery{Limit: n})
+}
+
+func (store *sqlStore) GetNetworkHistory(email, network string, n int) ([]messages.Message, error) {
+	return store.QueryHistory(email, HistoryQuery{Network: network, Limit: n})
+}
+
+func (store *sqlStore) GetChannelHistory(email, network, channel string, n int) ([]messages.Message, error) {
+	return store.QueryHistory(email, HistoryQuery{Network: network, Channel: channel, Limit: n})
+}
+
+func (store *sqlStore) QueryHistory(email string, query HistoryQuery) ([]messages.Message, error) {
+	var conds = []string{"email=?"}
+	var args = []interface{}{email}
+	if len(query.Network) > 0 {
+		conds = append(conds, "network=?")
+		args = append(args, query.Network)
+	}
+	if len(query.Channel) > 0 {
+		conds = append(conds, "channel=?")
+		args = append(args, query.Channel)
+	}
+	if query.Before > 0 {
+		conds = append(conds, "id<?")
+		args = append(args, query.Before)
+	}
+	if query.After > 0 {
+		conds = append(conds, "id>?")
+		args = append(args, query.After)
+	}
+	if query.Since > 0 {
+		conds = append(conds, "timestamp>=?")
+		args = append(args, query.Since)
+	}
+	if query.Until > 0 {
+		conds = append(conds, "timestamp<=?")
+		args = append(args, query.Until)
+	}
+
+	var order = "DESC"
+	if query.Ascending() {
+		order = "ASC"
+	}
+
+	var limit string
+	if query.Limit > 0 {
+		limit = " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	results, err := store.query("SELECT id, network, channel, timestamp, sender, command, message, ownmessage, preview, tags FROM messages WHERE "+
+		strings.Join(conds, " AND ")+" ORDER BY id "+order+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := scanMessages(results)
+	if query.Ascending() {
+		reverse(msgs)
+	}
+	return msgs, err
+}
+
+func scanMessages(results *sql.Rows) ([]messages.Message, error) {
+	defer results.Close()
+	var msgs []messages.Message
+	for results.Next() {
+		msg, err := scanMessage(results)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, results.Err()
+}
+
+// scanMessage scans the message in the current row. The columns after the
+// message columns are scanned into the extra destinations.
+func scanMessage(results *sql.Rows, extra ...interface{}) (messages.Message, error) {
+	var network, channel, sender, command, message string
+	var previewStr, tagsStr sql.NullString
+	var ownmessage bool
+	var timestamp, id int64
+
+	err := results.Scan(append([]interface{}{&id, &network, &channel, &timestamp, &sender, &command, &message, &ownmessage, &previewStr, &tagsStr}, extra...)...)
+	if err != nil {
+		return messages.Message{}, err
+	}
+
+	var pw = &messages.Preview{}
+	if len(previewStr.String) > 0 {
+		json.Unmarshal([]byte(previewStr.String), pw)
+	} else {
+		pw = nil
+	}
+
+	var tags map[string]string
+	if len(tagsStr.String) > 0 {
+		json.Unmarshal([]byte(tagsStr.String), &tags)
+	}
+
+	return messages.Message{
+		ID:        id,
+		Network:   network,
+		Channel:   channel,
+		Timestamp: timestamp,
+		Sender:    sender,
+		Command:   command,
+		Message:   message,
+		OwnMsg:    ownmessage,
+		Preview:   pw,
+		Tags:      tags,
+	}, nil
+}
+
+func (store *sqlStore) DeleteMessage(email string, id int64) error {
+	_, err := store.exec("DELETE FROM messages WHERE email=? AND id=?;", email, id)
+	return err
+}
+
+func (store *sqlStore) ClearChannel(email, network, channel string) error {
+	_, err := store.exec("DELETE FROM messages WHERE email=? AND network=? AND channel=?;", email, network, channel)
+	return err
+}
+
+func (store *sqlStore) ClearNetwork(email, network string) error {
+	_, err := store.exec("DELETE FROM messages WHERE email=? AND network=?;", email, network)
+	return err
+}
+
+func (store *sqlStore) ClearUser(email string) error {
+	_, err := store.exec("DELETE FROM messages WHERE email=?;", email)
+	return err
+}
+
+func (store *sqlStore) LastID(email string) (id int64, err error) {
+	var nullID sql.NullInt64
+	err = store.queryRow("SELECT MAX(id) FROM messages WHERE email=?", email).Scan(&nullID)
+	return nullID.Int64, err
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (store *sqlStore) Insert(email string, msg messages.Message) (int64, error) {
+	return store.insert(store.db, email, msg)
+}
+
+func (store *sqlStore) InsertBatch(batch []*Insertion) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, ins := range batch {
+		ins.Message.ID, err = store.insert(tx, ins.Email, ins.Message)
+		if err != nil {
+			tx.Rollback()
+			for _, ins := range batch {
+				ins.Message.ID = 0
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *sqlStore) insert(q queryer, email string, msg messages.Message) (id int64, err error) {
+	var preview = ""
+	if msg.Preview != nil {
+		data, err := json.Marshal(msg.Preview)
+		if err == nil {
+			preview = string(data)
+		}
+	}
+
+	var tags sql.NullString
+	if len(msg.Tags) > 0 {
+		data, err := json.Marshal(msg.Tags)
+		if err == nil {
+			tags = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+
+	query := "INSERT INTO messages (email, network, channel, timestamp, sender, command, message, ownmessage, preview, tags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	args := []interface{}{email, msg.Network, msg.Channel, msg.Timestamp, msg.Sender, msg.Command, msg.Message, msg.OwnMsg, preview, tags}
+	if store.dialect.Returning {
+		err = q.QueryRow(store.rebind(query+" RETURNING id;"), args...).Scan(&id)
+		return
+	}
+
+	result, err := q.Exec(store.rebind(query+";"), args...)
+	if err != nil {
+		return
+	}
+	return result.LastInsertId()
+}
@@ -0,0 +1,105 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"strings"
+	"unsafe"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// nativeEndian is the byte order of the platform, which SQLite uses for matchinfo
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+
+	sql.Register("sqlite3_mauirc", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("mauirc_rank", rankMatchInfo, true)
+		},
+	})
+}
+
+var sqlite = dialect{
+	Name:   "sqlite",
+	Driver: "sqlite3_mauirc",
+	CreateMessages: "CREATE TABLE IF NOT EXISTS messages (" +
+		"id INTEGER PRIMARY KEY AUTOINCREMENT," +
+		"email VARCHAR(255) NOT NULL," +
+		"network VARCHAR(255) NOT NULL," +
+		"channel VARCHAR(255) NOT NULL," +
+		"timestamp BIGINT NOT NULL," +
+		"sender VARCHAR(255) NOT NULL," +
+		"command VARCHAR(255) NOT NULL," +
+		"message TEXT NOT NULL," +
+		"ownmessage BOOLEAN NOT NULL," +
+		"preview TEXT" +
+		");",
+	TableExists: "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?",
+	UpsertReadMarker: "INSERT INTO read_markers (email, network, channel, message_id) VALUES (?, ?, ?, ?) " +
+		"ON CONFLICT (email, network, channel) DO UPDATE SET message_id=excluded.message_id " +
+		"WHERE read_markers.message_id < excluded.message_id;",
+	SearchFrom:  "messages JOIN messages_fts ON messages_fts.docid=messages.id",
+	SearchMatch: "messages_fts MATCH ?",
+	SearchRank:  "mauirc_rank(matchinfo(messages_fts, 'pcx'))",
+	SearchTerms: quoteFTSTerms,
+}
+
+// quoteFTSTerms turns each word of the given query into a quoted FTS phrase,
+// so that the query syntax characters in user input are matched literally.
+func quoteFTSTerms(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = "\"" + strings.Replace(term, "\"", "\"\"", -1) + "\""
+	}
+	return strings.Join(terms, " ")
+}
+
+// rankMatchInfo calculates the relevance of a FTS match from the output of
+// matchinfo(table, 'pcx'). The score of each phrase is the number of hits in
+// the row divided by the number of hits in all rows, so rare words weigh more.
+func rankMatchInfo(info []byte) float64 {
+	// matchinfo returns an array of native-endian unsigned 32-bit integers.
+	var ints = make([]uint32, len(info)/4)
+	for i := range ints {
+		ints[i] = nativeEndian.Uint32(info[i*4:])
+	}
+	if len(ints) < 2 {
+		return 0
+	}
+
+	phrases, cols := int(ints[0]), int(ints[1])
+	var score float64
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < cols; c++ {
+			i := 2 + 3*(p*cols+c)
+			if i+1 >= len(ints) || ints[i+1] == 0 {
+				continue
+			}
+			score += float64(ints[i]) / float64(ints[i+1])
+		}
+	}
+	return score
+}
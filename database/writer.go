@@ -0,0 +1,177 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database contains the database systems
+package database
+
+import (
+	"errors"
+	"expvar"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/maulogger"
+)
+
+// Write queue limits
+const (
+	// WriteQueueSize is the number of messages that can wait to be written
+	// before QueueInsert starts dropping messages.
+	WriteQueueSize = 1024
+	// MaxBatchSize is the maximum number of messages written in one transaction.
+	MaxBatchSize = 128
+)
+
+var log = maulogger.CreateSublogger("Database", maulogger.LevelInfo)
+
+// ErrQueueFull is passed to the insertion callback if the message was dropped because the write queue is full
+var ErrQueueFull = errors.New("write queue is full")
+
+// Write queue metrics, published at /debug/vars on the metrics address
+var (
+	metrics       = expvar.NewMap("database")
+	metricQueued  = new(expvar.Int)
+	metricWritten = new(expvar.Int)
+	metricBatches = new(expvar.Int)
+	metricFailed  = new(expvar.Int)
+	metricDropped = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("queued", metricQueued)
+	metrics.Set("written", metricWritten)
+	metrics.Set("batches", metricBatches)
+	metrics.Set("failed", metricFailed)
+	metrics.Set("dropped", metricDropped)
+	metrics.Set("queue-length", expvar.Func(func() interface{} {
+		if queue == nil {
+			return 0
+		}
+		return len(queue.insertions)
+	}))
+}
+
+// Insertion is a message waiting to be written into the database
+type Insertion struct {
+	Email   string
+	Message messages.Message
+	// Callback is called after the message has been written. If the write was
+	// successful, the ID of the message has been set. Optional.
+	Callback func(msg messages.Message, err error)
+}
+
+type writeQueue struct {
+	insertions chan *Insertion
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+var queue *writeQueue
+
+func startWriter() {
+	queue = &writeQueue{
+		insertions: make(chan *Insertion, WriteQueueSize),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go queue.loop()
+}
+
+// QueueInsert queues the given message to be written into the database in the
+// background. The callback is called from the writer goroutine once the
+// message has been written, so callbacks are called in the same order as the
+// messages were queued. If the queue is full, the message is dropped and the
+// callback is called immediately with ErrQueueFull, so that IRC handlers are
+// never blocked by a slow database.
+func QueueInsert(email string, msg messages.Message, callback func(msg messages.Message, err error)) {
+	ins := &Insertion{Email: email, Message: msg, Callback: callback}
+	metricQueued.Add(1)
+	select {
+	case queue.insertions <- ins:
+	default:
+		metricDropped.Add(1)
+		log.Warnf("Write queue is full (%d messages), dropping message to %s@%s of %s\n", WriteQueueSize, msg.Channel, msg.Network, email)
+		ins.done(ErrQueueFull)
+	}
+}
+
+func (wq *writeQueue) loop() {
+	defer close(wq.stopped)
+	for {
+		select {
+		case ins := <-wq.insertions:
+			wq.write(wq.collect(ins))
+		case <-wq.stop:
+			for len(wq.insertions) > 0 {
+				wq.write(wq.collect(<-wq.insertions))
+			}
+			return
+		}
+	}
+}
+
+// collect the given insertion and everything else that is already waiting in the queue into a batch
+func (wq *writeQueue) collect(first *Insertion) []*Insertion {
+	batch := []*Insertion{first}
+	for len(batch) < MaxBatchSize {
+		select {
+		case ins := <-wq.insertions:
+			batch = append(batch, ins)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (wq *writeQueue) write(batch []*Insertion) {
+	metricBatches.Add(1)
+	err := store.InsertBatch(batch)
+	if err == nil {
+		metricWritten.Add(int64(len(batch)))
+		for _, ins := range batch {
+			ins.done(nil)
+		}
+		return
+	}
+
+	// Write the messages one by one so that a single bad message doesn't prevent writing the rest.
+	log.Warnf("Failed to write batch of %d messages: %s\n", len(batch), err)
+	for _, ins := range batch {
+		ins.Message.ID, err = store.Insert(ins.Email, ins.Message)
+		if err != nil {
+			metricFailed.Add(1)
+			log.Errorf("Failed to write message from %s to %s@%s of %s: %s\n", ins.Message.Sender, ins.Message.Channel, ins.Message.Network, ins.Email, err)
+		} else {
+			metricWritten.Add(1)
+		}
+		ins.done(err)
+	}
+}
+
+func (ins *Insertion) done(err error) {
+	if ins.Callback != nil {
+		ins.Callback(ins.Message, err)
+	}
+}
+
+// stopWriter writes everything left in the queue and stops the writer
+func stopWriter() {
+	if queue == nil {
+		return
+	}
+	close(queue.stop)
+	<-queue.stopped
+}
@@ -23,19 +23,26 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 
 	interfaces "maunium.net/go/mauirc-server/interfaces"
 	"maunium.net/go/maulogger"
 )
 
-// Ports is the port->name mapping
-var Ports = make(map[int]string)
+// Key identifies the local end of an outgoing connection
+type Key struct {
+	IP   string
+	Port int
+}
+
+var ports = make(map[Key]string)
+var portsLock sync.RWMutex
 var ln net.Listener
 var log = maulogger.CreateSublogger("IDENT", maulogger.LevelInfo)
 
 // Load the IDENTd
 func Load(config interfaces.IdentConf) error {
-	var ipport = fmt.Sprintf("%s:%d", config.IP, config.Port)
+	var ipport = net.JoinHostPort(config.IP, strconv.Itoa(config.Port))
 	var err error
 	ln, err = net.Listen("tcp", ipport)
 	if err != nil {
@@ -83,10 +90,71 @@ func handleConn(socket net.Conn) {
 	if err != nil {
 		return
 	}
-	name, ok := Ports[localPort]
+	localIP, _, _ := net.SplitHostPort(socket.LocalAddr().String())
+	name, ok := lookup(localIP, localPort)
 	if !ok {
 		fmt.Fprintf(socket, "%d, %d : ERROR : NO-USER\r\n", localPort, remotePort)
 	} else {
 		fmt.Fprintf(socket, "%d, %d : USERID : UNIX : %s\r\n", localPort, remotePort, name)
 	}
 }
+
+// Add maps the local address of an outgoing connection to the given name
+func Add(addr net.Addr, name string) (Key, error) {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return Key{}, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Key{}, fmt.Errorf("Invalid port (%s): %s", portStr, err)
+	}
+
+	key := Key{IP: normalizeIP(host), Port: port}
+	portsLock.Lock()
+	ports[key] = name
+	portsLock.Unlock()
+	return key, nil
+}
+
+// Remove a mapping added with Add
+func Remove(key Key) {
+	portsLock.Lock()
+	delete(ports, key)
+	portsLock.Unlock()
+}
+
+// lookup finds the name mapped to the given local address. If there's no
+// exact match, e.g. because the IRC server connected back through NAT, the
+// mapping with the same port is used, but only if there's exactly one.
+// Otherwise the connections of different users couldn't be told apart.
+func lookup(ip string, port int) (string, bool) {
+	portsLock.RLock()
+	defer portsLock.RUnlock()
+	if name, ok := ports[Key{IP: normalizeIP(ip), Port: port}]; ok {
+		return name, true
+	}
+	var found string
+	var matches int
+	for key, name := range ports {
+		if key.Port == port {
+			found = name
+			matches++
+		}
+	}
+	if matches != 1 {
+		return "", false
+	}
+	return found, true
+}
+
+// normalizeIP converts IPv4-mapped IPv6 addresses to plain IPv4 and strips IPv6 zones
+func normalizeIP(host string) string {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
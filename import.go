@@ -0,0 +1,88 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	flag "maunium.net/go/mauflag"
+	"maunium.net/go/mauirc-server/util/logimport"
+	log "maunium.net/go/maulogger"
+)
+
+var importTimezone = flag.Make().LongKey("timezone").Default("Local").Usage("The time zone of the logs to import").String()
+var importNick = flag.Make().LongKey("nick").Default("").Usage("Your nick in the logs to import").String()
+
+// importLogs imports the given log files. The arguments are the email of the
+// user, the log format, the network, the channel and the log files.
+func importLogs(args []string) int {
+	if len(args) < 5 {
+		log.Fatalln("Usage: mauirc-server import <email> <znc|irssi|weechat> <network> <channel> <files...>")
+		return 10
+	}
+	email, format, network, channel, files := args[0], args[1], args[2], args[3], args[4:]
+
+	user := config.GetUser(email)
+	if user == nil {
+		log.Fatalln("User", email, "not found")
+		return 10
+	} else if user.GetNetwork(network) == nil {
+		log.Fatalln("Network", network, "of", email, "not found")
+		return 10
+	}
+
+	loc, err := time.LoadLocation(*importTimezone)
+	if err != nil {
+		log.Fatalln("Invalid time zone:", err)
+		return 10
+	}
+
+	importer, err := logimport.NewImporter(user.GetEmail())
+	if err != nil {
+		log.Fatalln("Failed to prepare import:", err)
+		return 11
+	}
+	importer.Progress = func(p logimport.Progress) {
+		log.Infoln("Imported", p.Imported, "messages,", p.Duplicates, "duplicates and", p.Skipped, "unparseable lines skipped so far")
+	}
+
+	for _, file := range files {
+		log.Infoln("Importing", file)
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalln("Failed to open", file+":", err)
+			return 11
+		}
+		err = importer.Import(format, f, logimport.Options{
+			Network:  network,
+			Channel:  channel,
+			Nick:     *importNick,
+			Location: loc,
+			FileName: filepath.Base(file),
+		})
+		f.Close()
+		if err != nil {
+			log.Fatalln("Failed to import", file+":", err)
+			return 11
+		}
+	}
+
+	status := importer.Status()
+	log.Infoln("Import finished:", status.Imported, "messages imported,", status.Duplicates, "duplicates and", status.Skipped, "unparseable lines skipped")
+	return 0
+}
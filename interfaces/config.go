@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/util/hub"
 )
 
 // Configuration contains the main config
@@ -31,10 +32,12 @@ type Configuration interface {
 
 	GetIDENTConfig() IdentConf
 
+	GetSQLType() string
 	GetSQLString() string
 	GetPath() string
 
 	GetMail() Mail
+	GetRetention() *Retention
 
 	GetUsers() UserList
 	GetUser(name string) User
@@ -43,6 +46,7 @@ type Configuration interface {
 
 	GetAddr() string
 	GetExternalAddr() string
+	GetMetricsAddr() string
 	TrustHeaders() bool
 
 	GetCookieSecret() []byte
@@ -64,6 +68,17 @@ type IdentConf struct {
 	Port    int    `json:"port"`
 }
 
+// Retention is a message history retention policy. Zero values mean no limit.
+type Retention struct {
+	MaxAge      int `yaml:"max-age-days,omitempty" json:"max-age-days,omitempty"`
+	MaxMessages int `yaml:"max-messages,omitempty" json:"max-messages,omitempty"`
+}
+
+// IsUnlimited checks if the policy doesn't limit the history in any way
+func (r Retention) IsUnlimited() bool {
+	return r.MaxAge <= 0 && r.MaxMessages <= 0
+}
+
 // UserList is a list of users that can be looped through
 type UserList interface {
 	ForEach(func(user User))
@@ -79,7 +94,8 @@ type User interface {
 	DeleteNetwork(name string) bool
 	AddNetwork(nw Network) bool
 	CreateNetwork(name string, data []byte) (Network, bool)
-	SendNetworkData(net Network)
+	// SendNetworkData sends the state of the given network with the given function, or to all clients if it's nil
+	SendNetworkData(net Network, send func(messages.Container))
 
 	GetEmail() string
 	GetNameFromEmail() string
@@ -97,18 +113,27 @@ type User interface {
 	NewAuthToken() string
 	CheckAuthToken(token string) bool
 
-	HandleCommand(data messages.Container)
+	HandleCommand(data messages.Container, protocol int, reply func(messages.Container))
 
 	GetGlobalScripts() []Script
 	AddGlobalScript(s Script) bool
 	RemoveGlobalScript(name string) bool
 	SaveGlobalScripts(path string) error
 
-	GetMessageChan() chan messages.Container
 	SendMessage(msg messages.Container)
+	Subscribe(name string) *hub.Subscription
+	GetHub() *hub.Hub
+	GetClients() []hub.Stats
 
 	GetSettings() interface{}
 	SetSettings(val interface{})
+
+	GetRetention() *Retention
+	SetRetention(r *Retention)
+	PruneHistory() int64
+
+	// GetBind gets the local address or hostname the networks of the user connect from
+	GetBind() string
 }
 
 // NetworkList is a list of networks that can be looped through
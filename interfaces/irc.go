@@ -18,9 +18,14 @@
 package interfaces
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
 	"maunium.net/go/libmauirc"
 	"maunium.net/go/mauirc-server/common/messages"
-	"maunium.net/go/mauirc-server/util/userlist"
+	"maunium.net/go/mauirc-server/util/clientcert"
+	"maunium.net/go/mauirc-server/util/isupport"
 )
 
 // Network is a single IRC network
@@ -42,6 +47,10 @@ type Network interface {
 	GetName() string
 	GetNick() string
 	GetNetData() messages.NetData
+	// HasCapability checks if the given IRCv3 capability is enabled on the connection
+	HasCapability(name string) bool
+	// GetCapabilities gets the names of all enabled IRCv3 capabilities
+	GetCapabilities() []string
 
 	SetName(name string)
 	SetNick(nick string)
@@ -54,6 +63,39 @@ type Network interface {
 	GetActiveChannels() ChannelDataList
 	GetAllChannels() []string
 
+	GetSASL() *SASL
+	SetSASL(sasl *SASL)
+
+	// GetClientCert gets the details of the TLS client certificate. nil if there's no certificate.
+	GetClientCert() (*clientcert.Info, error)
+	// SetClientCert replaces the client certificate with the given PEM encoded certificate and private key
+	SetClientCert(data []byte) (*clientcert.Info, error)
+	// GenerateClientCert replaces the client certificate with a new self-signed one
+	GenerateClientCert(keyType string) (*clientcert.Info, error)
+	RemoveClientCert() error
+
+	GetTLS() *TLS
+	SetTLS(settings *TLS)
+
+	// GetServers gets the alternate servers that are tried if the main one can't be reached
+	GetServers() []Server
+	SetServers(servers []Server)
+	GetReconnect() *Reconnect
+	SetReconnect(policy *Reconnect)
+	// GetBind gets the local address or hostname the network connects from
+	GetBind() string
+	// GetSupport gets the features the server has advertised with RPL_ISUPPORT
+	GetSupport() *isupport.Support
+	// GetProxy gets the proxy the network connects through, or nil if the connection is direct
+	GetProxy() *Proxy
+	SetProxy(settings *Proxy)
+
+	GetRetention() *Retention
+	SetRetention(r *Retention)
+	GetChannelRetention(channel string) *Retention
+	SetChannelRetention(channel string, r *Retention)
+	GetChannelRetentions() map[string]*Retention
+
 	GetScripts() []Script
 	AddScript(s Script) bool
 	RemoveScript(name string) bool
@@ -61,6 +103,82 @@ type Network interface {
 	LoadScripts(path string) error
 }
 
+// SASL contains the SASL authentication settings of a network
+type SASL struct {
+	// Mechanism is PLAIN, EXTERNAL or SCRAM-SHA-256
+	Mechanism string `yaml:"mechanism" json:"mechanism"`
+	Username  string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password  string `yaml:"password,omitempty" json:"password,omitempty"`
+	// AbortOnFailure disconnects from the network if the authentication fails
+	AbortOnFailure bool `yaml:"abort-on-failure,omitempty" json:"abort-on-failure,omitempty"`
+}
+
+// TLS contains the settings for verifying the certificate of an IRC server
+type TLS struct {
+	// CA is a PEM encoded CA certificate bundle that's trusted in addition to the system CAs
+	CA string `yaml:"ca,omitempty" json:"ca,omitempty"`
+	// Fingerprints contains the SHA-256 fingerprints of server certificates
+	// that are accepted even if they're not signed by a trusted CA.
+	Fingerprints []string `yaml:"fingerprints,omitempty" json:"fingerprints,omitempty"`
+	// Insecure disables the verification completely
+	Insecure bool `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+}
+
+// NormalizeFingerprint converts a fingerprint to lowercase hex without separators
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+}
+
+// IsPinned checks if the SHA-256 fingerprint of the given DER encoded certificate is pinned
+func (settings *TLS) IsPinned(cert []byte) bool {
+	sum := sha256.Sum256(cert)
+	fingerprint := hex.EncodeToString(sum[:])
+	for _, pinned := range settings.Fingerprints {
+		if NormalizeFingerprint(pinned) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Server is the address of an IRC server
+type Server struct {
+	IP   string `yaml:"ip" json:"ip"`
+	Port uint16 `yaml:"port" json:"port"`
+	SSL  bool   `yaml:"ssl" json:"ssl"`
+}
+
+// Reconnect is the reconnection policy of a network. The delay between
+// attempts doubles after each failed attempt, starting from MinDelay and
+// capped at MaxDelay. Zero values use the defaults.
+type Reconnect struct {
+	// Disabled disables reconnecting automatically
+	Disabled bool `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+	// MinDelay and MaxDelay are the bounds of the delay in seconds
+	MinDelay int `yaml:"min-delay,omitempty" json:"min-delay,omitempty"`
+	MaxDelay int `yaml:"max-delay,omitempty" json:"max-delay,omitempty"`
+	// MaxAttempts is the number of failed attempts in a row after which to give up. Zero means never.
+	MaxAttempts int `yaml:"max-attempts,omitempty" json:"max-attempts,omitempty"`
+}
+
+// Proxy contains the settings of a proxy that a network connects through
+type Proxy struct {
+	// Type is socks5, http or tor
+	Type string `yaml:"type" json:"type"`
+	// Address is the host:port of the proxy. Defaults to 127.0.0.1:9050 for Tor.
+	Address  string `yaml:"address,omitempty" json:"address,omitempty"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+}
+
+// STSPolicy is an IRCv3 Strict Transport Security policy received from a network
+type STSPolicy struct {
+	// Port is the port to use TLS on
+	Port uint16 `yaml:"port" json:"port"`
+	// Expires is the unix timestamp after which the policy is no longer valid
+	Expires int64 `yaml:"expires" json:"expires"`
+}
+
 // ChannelDataList contains a list of channel data objects
 type ChannelDataList interface {
 	Get(channel string) (ChannelData, bool)
@@ -72,7 +190,10 @@ type ChannelDataList interface {
 
 // ChannelData has basic channel data (topic, user list, etc)
 type ChannelData interface {
+	// GetUsers gets the nicks in the channel with their highest prefixes
 	GetUsers() []string
+	// GetMembers gets the details of the users in the channel
+	GetMembers() []messages.Member
 	GetName() string
 	GetTopic() string
 	GetNetwork() string
@@ -117,33 +238,14 @@ func (ml ModeList) RemoveMode(r rune, target string) ModeList {
 	return ml
 }
 
-// PrefixOf gets the prefix of the given user
-func (ml ModeList) PrefixOf(user string) rune {
-	level := 0
-	for _, mode := range ml {
-		if mode.Target == user {
-			lvl := ml.levelOfMode(mode.Mode)
-			if lvl > level {
-				level = lvl
-			}
+// RemoveModes removes the given mode with all targets
+func (ml ModeList) RemoveModes(r rune) ModeList {
+	for i := 0; i < len(ml); i++ {
+		if ml[i].Mode == r {
+			ml[i] = ml[len(ml)-1]
+			ml = ml[:len(ml)-1]
+			i--
 		}
 	}
-	return userlist.PrefixOf(level)
-}
-
-func (ml ModeList) levelOfMode(r rune) int {
-	switch r {
-	case 'q':
-		return 5
-	case 'a':
-		return 4
-	case 'o':
-		return 3
-	case 'h':
-		return 2
-	case 'v':
-		return 1
-	default:
-		return 0
-	}
+	return ml
 }
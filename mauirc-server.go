@@ -23,7 +23,6 @@ import (
 	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"maunium.net/go/libmauirc"
 	flag "maunium.net/go/mauflag"
 	cfg "maunium.net/go/mauirc-server/config"
@@ -37,6 +36,8 @@ import (
 var confPath = flag.Make().LongKey("config").ShortKey("c").Default("/etc/mauirc/").Usage("The path to mauIRC server configurations").String()
 var logPath = flag.Make().LongKey("logs").ShortKey("l").Default("/var/log/mauirc/").Usage("The path to mauIRC server logs").String()
 var debug = flag.Make().LongKey("debug").ShortKey("d").Default("false").Usage("Use to enable debug prints").Bool()
+var migrateOnly = flag.Make().LongKey("migrate-only").Default("false").Usage("Apply pending database migrations and exit").Bool()
+var dryRun = flag.Make().LongKey("dry-run").Default("false").Usage("Print pending database migrations without applying them and exit").Bool()
 var wantHelp, _ = flag.MakeHelpFlag()
 var config interfaces.Configuration
 var version = "2.0.0"
@@ -46,7 +47,7 @@ func init() {
 }
 
 func main() {
-	flag.SetHelpTitles(fmt.Sprintf("mauIRC Server %s - The IRC bouncer/backend system for mauIRC clients.", version), "mauirc-server [-h] [-d] [-c configPath] [-l logPath]")
+	flag.SetHelpTitles(fmt.Sprintf("mauIRC Server %s - The IRC bouncer/backend system for mauIRC clients.", version), "mauirc-server [-h] [-d] [-c configPath] [-l logPath] [--migrate-only] [--dry-run] [import [--timezone tz] [--nick nick] <email> <format> <network> <channel> <files...>]")
 	flag.Parse()
 	if *wantHelp {
 		flag.PrintHelp()
@@ -77,6 +78,53 @@ func main() {
 		config.GetMail().LoadTemplates(*confPath)
 	}
 
+	// The SQL string isn't logged, as it contains the database password
+	log.Debugln("Loading", config.GetSQLType(), "database")
+	err = database.Load(config.GetSQLType(), config.GetSQLString())
+	if err != nil {
+		log.Fatalln("Failed to load database:", err)
+		log.Close()
+		os.Exit(3)
+	}
+
+	if *dryRun {
+		pending, err := database.PendingMigrations()
+		if err != nil {
+			log.Fatalln("Failed to check database migrations:", err)
+			log.Close()
+			os.Exit(5)
+		}
+		log.Infoln(len(pending), "pending database migrations")
+		for _, migration := range pending {
+			log.Infoln("Pending migration", migration)
+		}
+		database.Close()
+		log.Close()
+		return
+	}
+
+	applied, err := database.Migrate()
+	for _, migration := range applied {
+		log.Infoln("Applied database migration", migration)
+	}
+	if err != nil {
+		log.Fatalln("Failed to migrate database:", err)
+		log.Close()
+		os.Exit(5)
+	} else if *migrateOnly {
+		log.Infoln("Database is up to date")
+		database.Close()
+		log.Close()
+		return
+	}
+
+	if flag.Arg(0) == "import" {
+		code := importLogs(flag.Args()[1:])
+		database.Close()
+		log.Close()
+		os.Exit(code)
+	}
+
 	if config.GetIDENTConfig().Enabled {
 		log.Debugln("Enabling the IDENTd")
 		err = ident.Load(config.GetIDENTConfig())
@@ -88,14 +136,6 @@ func main() {
 		go ident.Listen()
 	}
 
-	log.Debugln("Loading database with SQL string", config.GetSQLString())
-	err = database.Load(config.GetSQLString())
-	if err != nil {
-		log.Fatalln("Failed to load database:", err)
-		log.Close()
-		os.Exit(3)
-	}
-
 	log.Infoln("mauIRC server initialized. Connecting to IRC networks")
 	config.Connect()
 
@@ -133,6 +133,18 @@ func LoadEvent(env *vm.Env, evt *interfaces.Event) {
 	env.Define("SetOwnMsg", func(val bool) {
 		evt.Message.OwnMsg = val
 	})
+	env.Define("GetTags", func() map[string]string {
+		return evt.Message.Tags
+	})
+	env.Define("GetTag", func(name string) string {
+		return evt.Message.Tags[name]
+	})
+	env.Define("SetTag", func(name, val string) {
+		if evt.Message.Tags == nil {
+			evt.Message.Tags = make(map[string]string)
+		}
+		evt.Message.Tags[name] = val
+	})
 	env.Define("IsCancelled", func() bool {
 		return evt.Cancelled
 	})
@@ -0,0 +1,71 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package casemap contains the IRC casemappings
+package casemap
+
+import (
+	"strings"
+)
+
+// Mapping is an IRC casemapping as advertised in the CASEMAPPING token of RPL_ISUPPORT
+type Mapping string
+
+// Known casemappings
+const (
+	// ASCII only maps A-Z to a-z
+	ASCII Mapping = "ascii"
+	// RFC1459 also maps []\~ to {}|^
+	RFC1459 Mapping = "rfc1459"
+	// RFC1459Strict is RFC1459 without mapping ~ to ^
+	RFC1459Strict Mapping = "rfc1459-strict"
+	// RFC7613 maps unicode nicks. It's approximated with unicode lowercasing.
+	RFC7613 Mapping = "rfc7613"
+)
+
+// Default is the casemapping used if the server doesn't advertise one
+const Default = RFC1459
+
+var (
+	asciiLower = strings.NewReplacer(
+		"A", "a", "B", "b", "C", "c", "D", "d", "E", "e", "F", "f", "G", "g",
+		"H", "h", "I", "i", "J", "j", "K", "k", "L", "l", "M", "m", "N", "n",
+		"O", "o", "P", "p", "Q", "q", "R", "r", "S", "s", "T", "t", "U", "u",
+		"V", "v", "W", "w", "X", "x", "Y", "y", "Z", "z")
+	rfc1459Lower       = strings.NewReplacer("[", "{", "]", "}", "\\", "|", "~", "^")
+	rfc1459StrictLower = strings.NewReplacer("[", "{", "]", "}", "\\", "|")
+)
+
+// ToLower folds the given nick or channel name to lowercase. Names that are
+// equal in the casemapping have the same lowercase form. Unknown casemappings
+// are treated as rfc1459.
+func (mapping Mapping) ToLower(name string) string {
+	switch mapping {
+	case ASCII:
+		return asciiLower.Replace(name)
+	case RFC1459Strict:
+		return rfc1459StrictLower.Replace(asciiLower.Replace(name))
+	case RFC7613:
+		return strings.ToLower(name)
+	default:
+		return rfc1459Lower.Replace(asciiLower.Replace(name))
+	}
+}
+
+// Equal checks if the given names are equal in the casemapping
+func (mapping Mapping) Equal(a, b string) bool {
+	return mapping.ToLower(a) == mapping.ToLower(b)
+}
@@ -0,0 +1,58 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package casemap
+
+import "testing"
+
+func TestToLower(t *testing.T) {
+	tests := []struct {
+		mapping  Mapping
+		input    string
+		expected string
+	}{
+		{ASCII, "Nick[]\\~", "nick[]\\~"},
+		{RFC1459, "Nick[]\\~", "nick{}|^"},
+		{RFC1459Strict, "Nick[]\\~", "nick{}|~"},
+		{RFC7613, "NÏCK[]", "nïck[]"},
+		{ASCII, "NÏCK", "nÏck"},
+		{Mapping("unknown"), "Nick[]\\~", "nick{}|^"},
+	}
+	for _, test := range tests {
+		if output := test.mapping.ToLower(test.input); output != test.expected {
+			t.Errorf("%s: ToLower(%q) = %q, expected %q", test.mapping, test.input, output, test.expected)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		mapping  Mapping
+		a, b     string
+		expected bool
+	}{
+		{RFC1459, "#Foo[1]", "#foo{1}", true},
+		{ASCII, "#Foo[1]", "#foo{1}", false},
+		{ASCII, "#Foo[1]", "#FOO[1]", true},
+		{RFC1459Strict, "a~", "A^", false},
+		{RFC1459, "a~", "A^", true},
+	}
+	for _, test := range tests {
+		if output := test.mapping.Equal(test.a, test.b); output != test.expected {
+			t.Errorf("%s: Equal(%q, %q) = %t, expected %t", test.mapping, test.a, test.b, output, test.expected)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package clientcert generates and loads the TLS client certificates used to identify to IRC networks
+package clientcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Key types
+const (
+	RSA   = "rsa"
+	ECDSA = "ecdsa"
+)
+
+// Validity is how long generated certificates are valid. Services only care
+// about the fingerprint, so the expiry doesn't really matter.
+const Validity = 10 * 365 * 24 * time.Hour
+
+// Info contains the details of a client certificate
+type Info struct {
+	Subject  string `json:"subject"`
+	NotAfter int64  `json:"not-after"`
+	// SHA256 and SHA512 are the hex-encoded fingerprints of the certificate,
+	// which are registered with services for CertFP.
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// Generate a self-signed certificate and its private key. The result is PEM
+// encoded and contains the certificate followed by the key.
+func Generate(commonName, keyType string) ([]byte, error) {
+	var key interface{}
+	var public interface{}
+	var err error
+	switch keyType {
+	case RSA, "":
+		var rsaKey *rsa.PrivateKey
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		key, public = rsaKey, rsaKey.Public()
+	case ECDSA:
+		var ecKey *ecdsa.PrivateKey
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		key, public = ecKey, ecKey.Public()
+	default:
+		return nil, fmt.Errorf("Unknown key type %s", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...), nil
+}
+
+// Load parses a PEM encoded certificate and private key
+func Load(data []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return cert, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+// GetInfo gets the details and fingerprints of the given certificate
+func GetInfo(cert tls.Certificate) Info {
+	der := cert.Certificate[0]
+	sum256 := sha256.Sum256(der)
+	sum512 := sha512.Sum512(der)
+	info := Info{
+		SHA256: hex.EncodeToString(sum256[:]),
+		SHA512: hex.EncodeToString(sum512[:]),
+	}
+	if cert.Leaf != nil {
+		info.Subject = cert.Leaf.Subject.String()
+		info.NotAfter = cert.Leaf.NotAfter.Unix()
+	}
+	return info
+}
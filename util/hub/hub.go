@@ -0,0 +1,283 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package hub distributes messages to all connected clients of an user
+package hub
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+var metrics = expvar.NewMap("hub")
+
+// Policy decides what to do when the buffer of a client is full
+type Policy int
+
+// Slow client policies
+const (
+	// Disconnect closes the subscription of the client. The client can then
+	// reconnect and fetch the missed messages from the history.
+	Disconnect Policy = iota
+	// DropOldest discards the oldest buffered message to make room for the new one.
+	DropOldest
+)
+
+// ParsePolicy parses a policy name. Unknown names default to Disconnect.
+func ParsePolicy(name string) Policy {
+	switch strings.ToLower(name) {
+	case "drop-oldest", "dropoldest":
+		return DropOldest
+	default:
+		return Disconnect
+	}
+}
+
+func (policy Policy) String() string {
+	switch policy {
+	case DropOldest:
+		return "drop-oldest"
+	default:
+		return "disconnect"
+	}
+}
+
+// Hub sends messages to all subscriptions of an user
+type Hub struct {
+	bufferSize int
+	replaySize int
+	policy     Policy
+	epoch      int64
+
+	lock    sync.Mutex
+	subs    map[uint64]*Subscription
+	nextID  uint64
+	seq     uint64
+	history []messages.Container
+}
+
+// New creates a hub that gives every subscription a buffer of bufferSize
+// messages and keeps the last replaySize messages for resuming sessions.
+func New(bufferSize, replaySize int, policy Policy) *Hub {
+	return &Hub{
+		bufferSize: bufferSize,
+		replaySize: replaySize,
+		policy:     policy,
+		epoch:      time.Now().UnixNano(),
+		subs:       make(map[uint64]*Subscription),
+	}
+}
+
+// Epoch identifies the hub. Sequence numbers are only comparable within an epoch.
+func (hub *Hub) Epoch() int64 {
+	return hub.epoch
+}
+
+// Subscription is the message queue of a single client
+type Subscription struct {
+	ID     uint64
+	Name   string
+	Since  time.Time
+	hub    *Hub
+	ch     chan messages.Container
+	closed bool
+
+	queued  uint64
+	dropped uint64
+}
+
+// Stats contains the state of a subscription
+type Stats struct {
+	ID       uint64    `json:"id"`
+	Name     string    `json:"name"`
+	Since    time.Time `json:"since"`
+	Buffered int       `json:"buffered"`
+	Capacity int       `json:"capacity"`
+	Queued   uint64    `json:"queued"`
+	Dropped  uint64    `json:"dropped"`
+	Policy   string    `json:"policy"`
+}
+
+// Subscribe creates a new subscription with the given human-readable name
+func (hub *Hub) Subscribe(name string) *Subscription {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	return hub.subscribe(name)
+}
+
+// Resume creates a new subscription for a client that has received all
+// messages up to lastSeq in the given epoch. If the missed messages are still
+// in memory, they're returned and ok is true. The current sequence number is
+// returned in any case, as the subscription receives all messages after it.
+func (hub *Hub) Resume(name string, epoch int64, lastSeq uint64) (sub *Subscription, replay []messages.Container, seq uint64, ok bool) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	sub = hub.subscribe(name)
+	seq = hub.seq
+	if epoch != hub.epoch || lastSeq > hub.seq {
+		return
+	} else if lastSeq == hub.seq {
+		return sub, nil, seq, true
+	} else if len(hub.history) == 0 || hub.history[0].Seq > lastSeq+1 {
+		return
+	}
+
+	skip := int(lastSeq + 1 - hub.history[0].Seq)
+	replay = make([]messages.Container, len(hub.history)-skip)
+	copy(replay, hub.history[skip:])
+	return sub, replay, seq, true
+}
+
+// subscribe creates a subscription. The hub lock must be held.
+func (hub *Hub) subscribe(name string) *Subscription {
+	hub.nextID++
+	sub := &Subscription{
+		ID:    hub.nextID,
+		Name:  name,
+		Since: time.Now(),
+		hub:   hub,
+		ch:    make(chan messages.Container, hub.bufferSize),
+	}
+	hub.subs[sub.ID] = sub
+	metrics.Add("subscriptions", 1)
+	return sub
+}
+
+// Publish assigns the next sequence number to the given message and sends it
+// to all subscriptions. It never blocks.
+func (hub *Hub) Publish(msg messages.Container) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	hub.seq++
+	msg.Seq = hub.seq
+	if hub.replaySize > 0 {
+		hub.history = append(hub.history, msg)
+		if len(hub.history) > hub.replaySize {
+			hub.history = hub.history[len(hub.history)-hub.replaySize:]
+		}
+	}
+	for _, sub := range hub.subs {
+		sub.send(msg)
+	}
+}
+
+// Len returns the number of subscriptions
+func (hub *Hub) Len() int {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	return len(hub.subs)
+}
+
+// Stats gets the state of all subscriptions
+func (hub *Hub) Stats() []Stats {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	var stats = make([]Stats, 0, len(hub.subs))
+	for _, sub := range hub.subs {
+		stats = append(stats, sub.stats())
+	}
+	return stats
+}
+
+// C returns the channel the messages are received from. The channel is
+// closed when the subscription is closed or the client is too slow.
+func (sub *Subscription) C() <-chan messages.Container {
+	return sub.ch
+}
+
+// Send sends a message to this subscription only. The message doesn't get a
+// sequence number, as it's not a part of the stream all clients receive.
+func (sub *Subscription) Send(msg messages.Container) {
+	sub.hub.lock.Lock()
+	defer sub.hub.lock.Unlock()
+	sub.send(msg)
+}
+
+// Close removes the subscription from the hub
+func (sub *Subscription) Close() {
+	sub.hub.lock.Lock()
+	defer sub.hub.lock.Unlock()
+	sub.close()
+}
+
+// Stats gets the state of the subscription
+func (sub *Subscription) Stats() Stats {
+	sub.hub.lock.Lock()
+	defer sub.hub.lock.Unlock()
+	return sub.stats()
+}
+
+// send queues the message. The hub lock must be held.
+func (sub *Subscription) send(msg messages.Container) {
+	if sub.closed {
+		return
+	}
+
+	select {
+	case sub.ch <- msg:
+		atomic.AddUint64(&sub.queued, 1)
+		return
+	default:
+	}
+
+	metrics.Add("slow", 1)
+	switch sub.hub.policy {
+	case DropOldest:
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- msg:
+			atomic.AddUint64(&sub.queued, 1)
+		default:
+		}
+		atomic.AddUint64(&sub.dropped, 1)
+		metrics.Add("dropped", 1)
+	default:
+		metrics.Add("disconnected", 1)
+		sub.close()
+	}
+}
+
+// close closes the channel and removes the subscription. The hub lock must be held.
+func (sub *Subscription) close() {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(sub.hub.subs, sub.ID)
+	metrics.Add("subscriptions", -1)
+}
+
+func (sub *Subscription) stats() Stats {
+	return Stats{
+		ID:       sub.ID,
+		Name:     sub.Name,
+		Since:    sub.Since,
+		Buffered: len(sub.ch),
+		Capacity: cap(sub.ch),
+		Queued:   atomic.LoadUint64(&sub.queued),
+		Dropped:  atomic.LoadUint64(&sub.dropped),
+		Policy:   sub.hub.policy.String(),
+	}
+}
@@ -0,0 +1,91 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package irclog formats messages as classic IRC log lines
+package irclog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+// TimeFormat is the format of the timestamp at the start of each line
+const TimeFormat = "15:04:05"
+
+// DayFormat is the format of the date in day change lines
+const DayFormat = "Mon Jan 02 2006"
+
+// Line formats the given message as a log line with a [HH:MM:SS] timestamp in the given location
+func Line(msg messages.Message, loc *time.Location) string {
+	return fmt.Sprintf("[%s] %s", msg.Time().In(loc).Format(TimeFormat), Format(msg))
+}
+
+// DayChanged formats a day change line for the given day
+func DayChanged(day time.Time) string {
+	return "--- Day changed " + day.Format(DayFormat)
+}
+
+// SameDay checks if the given times are on the same date
+func SameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Format formats the given message without a timestamp
+func Format(msg messages.Message) string {
+	switch msg.Command {
+	case "privmsg":
+		return fmt.Sprintf("<%s> %s", msg.Sender, msg.Message)
+	case "action":
+		return fmt.Sprintf("* %s %s", msg.Sender, msg.Message)
+	case "notice":
+		return fmt.Sprintf("-%s- %s", msg.Sender, msg.Message)
+	case "join":
+		return fmt.Sprintf("*** Joins: %s", msg.Sender)
+	case "part":
+		return fmt.Sprintf("*** Parts: %s%s", msg.Sender, reason(msg.Message))
+	case "quit":
+		return fmt.Sprintf("*** Quits: %s%s", msg.Sender, reason(msg.Message))
+	case "kick":
+		parts := strings.SplitN(msg.Message, ":", 2)
+		var kickReason string
+		if len(parts) > 1 {
+			kickReason = parts[1]
+		}
+		return fmt.Sprintf("*** %s was kicked by %s%s", parts[0], msg.Sender, reason(kickReason))
+	case "mode":
+		return fmt.Sprintf("*** %s sets mode: %s", msg.Sender, msg.Message)
+	case "nick":
+		return fmt.Sprintf("*** %s is now known as %s", msg.Sender, msg.Message)
+	case "topic":
+		return fmt.Sprintf("*** %s changes topic to '%s'", msg.Sender, msg.Message)
+	case "invited":
+		return fmt.Sprintf("*** %s invited %s", msg.Sender, msg.Message)
+	default:
+		return fmt.Sprintf("*** %s %s %s", msg.Sender, msg.Command, msg.Message)
+	}
+}
+
+func reason(r string) string {
+	if len(r) == 0 {
+		return ""
+	}
+	return " (" + r + ")"
+}
@@ -0,0 +1,399 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ircv3 contains the IRCv3 capability negotiation
+package ircv3
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	msg "github.com/sorcix/irc"
+	"maunium.net/go/mauirc-server/interfaces"
+)
+
+// maxRequestLength is the maximum length of the capability list in a single CAP REQ
+const maxRequestLength = 400
+
+// Negotiator negotiates the capabilities of a single IRC connection.
+//
+// CAP LS 302 is sent when the connection is opened, which suspends the
+// registration until CAP END is sent. The capabilities that have been
+// registered with Register are requested if the server supports them and CAP
+// END is sent when the server has replied to all requests and there are no
+// holds left. Capabilities added and removed later with CAP NEW and CAP DEL
+// are handled the same way.
+type Negotiator struct {
+	net     interfaces.Network
+	send    func(*msg.Message)
+	changed func()
+	lock    sync.Mutex
+
+	// available contains the capabilities the server supports and their values
+	available map[string]string
+	// enabled contains the capabilities the server has acknowledged
+	enabled map[string]string
+	// pending contains the capabilities that have been requested but not acknowledged
+	pending map[string]bool
+	// listing is true while receiving a multi-line CAP LS reply
+	listing bool
+	// negotiating is true until CAP END is sent or the connection is registered
+	negotiating bool
+	// holds is the number of features that are still negotiating something
+	holds int
+}
+
+// NewNegotiator creates a capability negotiator for the given network. The
+// send function is used to send the CAP commands to the server and the
+// changed function is called after capabilities have been enabled or disabled.
+func NewNegotiator(net interfaces.Network, send func(*msg.Message), changed func()) *Negotiator {
+	n := &Negotiator{net: net, send: send, changed: changed}
+	n.reset()
+	return n
+}
+
+func (n *Negotiator) reset() {
+	n.available = make(map[string]string)
+	n.enabled = make(map[string]string)
+	n.pending = make(map[string]bool)
+	n.listing = false
+	n.negotiating = false
+	n.holds = 0
+}
+
+// Start the negotiation. Must be called right after connecting, before the
+// server has completed the registration.
+func (n *Negotiator) Start() {
+	n.lock.Lock()
+	n.reset()
+	n.negotiating = true
+	n.lock.Unlock()
+	n.sendCap("LS", "302")
+}
+
+// Registered marks the connection as registered. Servers that don't support
+// capabilities complete the registration without any CAP replies.
+func (n *Negotiator) Registered() {
+	n.lock.Lock()
+	n.negotiating = false
+	n.holds = 0
+	n.lock.Unlock()
+}
+
+// Disconnected clears the state of the negotiator and disables all capabilities
+func (n *Negotiator) Disconnected() {
+	n.lock.Lock()
+	removed := n.enabledNames()
+	n.reset()
+	n.lock.Unlock()
+	for _, name := range removed {
+		n.disabled(name)
+	}
+	if len(removed) > 0 {
+		n.changed()
+	}
+}
+
+// Hold delays CAP END until Release is called. Features that need to do
+// something before the registration completes, like SASL, should call Hold
+// when their capability is enabled.
+func (n *Negotiator) Hold() {
+	n.lock.Lock()
+	n.holds++
+	n.lock.Unlock()
+}
+
+// Release removes a hold added with Hold and ends the negotiation if there
+// are no holds or pending requests left.
+func (n *Negotiator) Release() {
+	n.lock.Lock()
+	if n.holds > 0 {
+		n.holds--
+	}
+	end := n.shouldEnd()
+	n.lock.Unlock()
+	if end {
+		n.sendCap("END")
+	}
+}
+
+// Negotiating checks if the capability negotiation is still in progress,
+// i.e. CAP END hasn't been sent and the connection isn't registered.
+func (n *Negotiator) Negotiating() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.negotiating
+}
+
+// IsEnabled checks if the server has acknowledged the given capability
+func (n *Negotiator) IsEnabled(name string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	_, ok := n.enabled[name]
+	return ok
+}
+
+// Value gets the value the server advertised for the given capability
+func (n *Negotiator) Value(name string) (string, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	value, ok := n.available[name]
+	return value, ok
+}
+
+// enabledNames gets the names of all enabled capabilities in alphabetical
+// order. The lock must be held.
+func (n *Negotiator) enabledNames() []string {
+	var names = make([]string, 0, len(n.enabled))
+	for name := range n.enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List gets the names of all enabled capabilities in alphabetical order
+func (n *Negotiator) List() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.enabledNames()
+}
+
+// Handle a CAP message from the server
+func (n *Negotiator) Handle(evt *msg.Message) {
+	params := evt.Params
+	if len(evt.Trailing) > 0 && (len(params) == 0 || params[len(params)-1] != evt.Trailing) {
+		params = append(params, evt.Trailing)
+	} else if len(evt.Trailing) == 0 && (len(params) == 2 || (len(params) == 3 && params[2] == "*")) {
+		// The capability list is empty, e.g. CAP * LS :
+		params = append(params, "")
+	}
+	if len(params) < 3 {
+		return
+	}
+
+	// The parameters are the target, the subcommand, an optional * and the capability list
+	var more = len(params) > 3 && params[2] == "*"
+	var caps = strings.Fields(params[len(params)-1])
+	switch strings.ToUpper(params[1]) {
+	case "LS":
+		n.ls(caps, more)
+	case "ACK":
+		n.ack(caps)
+	case "NAK":
+		n.nak(caps)
+	case "NEW":
+		n.lock.Lock()
+		n.addAvailable(caps)
+		n.lock.Unlock()
+		n.request(caps)
+	case "DEL":
+		n.del(caps)
+	}
+}
+
+// HandleInvalid handles ERR_INVALIDCAPCMD by giving up the negotiation
+func (n *Negotiator) HandleInvalid(evt *msg.Message) {
+	n.lock.Lock()
+	end := n.negotiating
+	n.pending = make(map[string]bool)
+	n.holds = 0
+	n.lock.Unlock()
+	if end {
+		n.sendCap("END")
+	}
+}
+
+func (n *Negotiator) ls(caps []string, more bool) {
+	n.lock.Lock()
+	if !n.listing {
+		n.available = make(map[string]string)
+	}
+	n.addAvailable(caps)
+	n.listing = more
+	var names = make([]string, 0, len(n.available))
+	for name := range n.available {
+		names = append(names, name)
+	}
+	n.lock.Unlock()
+
+	if !more {
+		sort.Strings(names)
+		n.request(names)
+	}
+}
+
+// addAvailable adds the given capabilities to the available map. The lock must be held.
+func (n *Negotiator) addAvailable(caps []string) {
+	for _, c := range caps {
+		parts := strings.SplitN(c, "=", 2)
+		var value string
+		if len(parts) > 1 {
+			value = parts[1]
+		}
+		n.available[parts[0]] = value
+	}
+}
+
+// request requests the given available capabilities that have been
+// registered and are wanted on this network.
+func (n *Negotiator) request(caps []string) {
+	var candidates = make(map[string]string)
+	n.lock.Lock()
+	for _, name := range caps {
+		name = strings.SplitN(name, "=", 2)[0]
+		if _, enabled := n.enabled[name]; !enabled && !n.pending[name] {
+			candidates[name] = n.available[name]
+		}
+	}
+	n.lock.Unlock()
+
+	// The callbacks are called without holding the lock, as they may use the negotiator
+	var wanted []string
+	for _, name := range caps {
+		name = strings.SplitN(name, "=", 2)[0]
+		if value, ok := candidates[name]; ok && n.wants(name, value) {
+			wanted = append(wanted, name)
+		}
+		delete(candidates, name)
+	}
+
+	n.lock.Lock()
+	for _, name := range wanted {
+		n.pending[name] = true
+	}
+	end := n.shouldEnd()
+	n.lock.Unlock()
+
+	var line string
+	for _, name := range wanted {
+		if len(line) > 0 && len(line)+len(name)+1 > maxRequestLength {
+			n.sendCap("REQ", line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += name
+	}
+	if len(line) > 0 {
+		n.sendCap("REQ", line)
+	} else if end {
+		n.sendCap("END")
+	}
+}
+
+// wants checks if a registered capability wants to be enabled and tells the
+// capability that the server advertised it.
+func (n *Negotiator) wants(name, value string) bool {
+	capability, ok := get(name)
+	if !ok {
+		return false
+	} else if capability.Advertised != nil {
+		capability.Advertised(n.net, value)
+	}
+	return capability.Want == nil || capability.Want(n.net, value)
+}
+
+func (n *Negotiator) ack(caps []string) {
+	var added, removed []string
+	n.lock.Lock()
+	for _, name := range caps {
+		if strings.HasPrefix(name, "-") {
+			name = name[1:]
+			delete(n.enabled, name)
+			removed = append(removed, name)
+		} else {
+			n.enabled[name] = n.available[name]
+			added = append(added, name)
+		}
+		delete(n.pending, name)
+	}
+	n.lock.Unlock()
+
+	for _, name := range removed {
+		n.disabled(name)
+	}
+	for _, name := range added {
+		if capability, ok := get(name); ok && capability.Enabled != nil {
+			value, _ := n.Value(name)
+			capability.Enabled(n.net, n, value)
+		}
+	}
+	n.endIfDone()
+	n.changed()
+}
+
+func (n *Negotiator) nak(caps []string) {
+	n.lock.Lock()
+	for _, name := range caps {
+		delete(n.pending, strings.TrimPrefix(name, "-"))
+	}
+	n.lock.Unlock()
+	n.endIfDone()
+}
+
+func (n *Negotiator) del(caps []string) {
+	var removed []string
+	n.lock.Lock()
+	for _, name := range caps {
+		name = strings.SplitN(name, "=", 2)[0]
+		delete(n.available, name)
+		delete(n.pending, name)
+		if _, ok := n.enabled[name]; ok {
+			delete(n.enabled, name)
+			removed = append(removed, name)
+		}
+	}
+	n.lock.Unlock()
+
+	for _, name := range removed {
+		n.disabled(name)
+	}
+	if len(removed) > 0 {
+		n.changed()
+	}
+}
+
+func (n *Negotiator) disabled(name string) {
+	if capability, ok := get(name); ok && capability.Disabled != nil {
+		capability.Disabled(n.net)
+	}
+}
+
+// shouldEnd checks if CAP END should be sent now and marks the negotiation
+// as ended if so. The lock must be held.
+func (n *Negotiator) shouldEnd() bool {
+	if n.negotiating && !n.listing && len(n.pending) == 0 && n.holds == 0 {
+		n.negotiating = false
+		return true
+	}
+	return false
+}
+
+func (n *Negotiator) endIfDone() {
+	n.lock.Lock()
+	end := n.shouldEnd()
+	n.lock.Unlock()
+	if end {
+		n.sendCap("END")
+	}
+}
+
+func (n *Negotiator) sendCap(params ...string) {
+	n.send(&msg.Message{Command: msg.CAP, Params: params})
+}
@@ -0,0 +1,71 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ircv3 contains the IRCv3 capability negotiation
+package ircv3
+
+import (
+	"sync"
+
+	"maunium.net/go/mauirc-server/interfaces"
+)
+
+// Capability is an IRCv3 capability that a feature wants to use
+type Capability struct {
+	// Name is the name of the capability, e.g. server-time
+	Name string
+	// Want decides whether the capability should be requested on the given
+	// network based on the value the server advertised. nil means always.
+	Want func(net interfaces.Network, value string) bool
+	// Advertised is called when the server advertises the capability in CAP
+	// LS or CAP NEW, before deciding whether to request it. Optional.
+	Advertised func(net interfaces.Network, value string)
+	// Enabled is called after the server has acknowledged the capability. Optional.
+	Enabled func(net interfaces.Network, n *Negotiator, value string)
+	// Disabled is called after the capability has been removed or the
+	// connection has been closed. Optional.
+	Disabled func(net interfaces.Network)
+}
+
+var registry = make(map[string]Capability)
+var registryLock sync.RWMutex
+
+// Register a capability. The capability will be requested from all servers
+// that support it. Registering a capability with the same name again
+// replaces the old one.
+func Register(capability Capability) {
+	registryLock.Lock()
+	registry[capability.Name] = capability
+	registryLock.Unlock()
+}
+
+// Registered gets the names of all registered capabilities
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	var names = make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	return names
+}
+
+func get(name string) (Capability, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	capability, ok := registry[name]
+	return capability, ok
+}
@@ -0,0 +1,97 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ircv3 contains the IRCv3 capability negotiation
+package ircv3
+
+import (
+	"strings"
+	"time"
+)
+
+// SplitTags removes the IRCv3 message tags from the start of a raw line. The
+// returned map is nil if the line doesn't have tags.
+func SplitTags(line string) (map[string]string, string) {
+	if len(line) == 0 || line[0] != '@' {
+		return nil, line
+	}
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return nil, line
+	}
+	return ParseTags(line[1:end]), strings.TrimLeft(line[end+1:], " ")
+}
+
+// ParseTags parses the tags part of a line (without the @) into a map
+func ParseTags(raw string) map[string]string {
+	var tags = make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if len(tag) == 0 {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) == 1 {
+			tags[parts[0]] = ""
+			continue
+		}
+		tags[parts[0]] = unescapeTag(parts[1])
+	}
+	return tags
+}
+
+// unescapeTag decodes the escapes in a tag value. Unknown escapes are replaced
+// with the escaped character and a trailing lone backslash is dropped.
+func unescapeTag(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var buf = make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf = append(buf, value[i])
+			continue
+		} else if i+1 >= len(value) {
+			break
+		}
+		i++
+		switch value[i] {
+		case ':':
+			buf = append(buf, ';')
+		case 's':
+			buf = append(buf, ' ')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		default:
+			buf = append(buf, value[i])
+		}
+	}
+	return string(buf)
+}
+
+// ServerTime gets the time from the time tag of a message, or false if the tag is missing or invalid
+func ServerTime(tags map[string]string) (time.Time, bool) {
+	value, ok := tags["time"]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
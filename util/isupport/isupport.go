@@ -0,0 +1,365 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package isupport contains the parser for the RPL_ISUPPORT features of IRC servers
+package isupport
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mauirc-server/util/casemap"
+)
+
+// Defaults used until the server advertises something else
+const (
+	DefaultPrefix    = "(qaohv)~&@%+"
+	DefaultChanTypes = "#&"
+	DefaultChanModes = "beI,k,l,imnpst"
+	DefaultModes     = 3
+)
+
+// Mode types as defined by the CHANMODES token
+const (
+	// ModeList modes add or remove an address from a list and always take a parameter
+	ModeList = iota
+	// ModeAlways modes always take a parameter
+	ModeAlways
+	// ModeSet modes only take a parameter when set
+	ModeSet
+	// ModeNever modes never take a parameter
+	ModeNever
+	// ModePrefix modes give a nick prefix to the user in the parameter
+	ModePrefix
+	// ModeUnknown modes aren't advertised by the server
+	ModeUnknown
+)
+
+// Support contains the features a server has advertised with RPL_ISUPPORT (005)
+type Support struct {
+	lock   sync.RWMutex
+	tokens map[string]string
+
+	prefixModes string
+	prefixes    string
+	chanTypes   string
+	chanModes   [4]string
+	caseMapping casemap.Mapping
+	nickLen     int
+	topicLen    int
+	modes       int
+}
+
+// New creates a Support with the defaults
+func New() *Support {
+	support := &Support{tokens: make(map[string]string)}
+	support.parsePrefix(DefaultPrefix)
+	support.chanTypes = DefaultChanTypes
+	support.parseChanModes(DefaultChanModes)
+	support.caseMapping = casemap.Default
+	support.modes = DefaultModes
+	return support
+}
+
+// Parse the tokens of an RPL_ISUPPORT message. The parameters must not
+// contain the target nick or the trailing "are supported by this server".
+func (support *Support) Parse(tokens []string) {
+	support.lock.Lock()
+	defer support.lock.Unlock()
+	for _, token := range tokens {
+		if len(token) == 0 {
+			continue
+		} else if token[0] == '-' {
+			name := strings.ToUpper(token[1:])
+			delete(support.tokens, name)
+			support.apply(name, "", true)
+			continue
+		}
+
+		parts := strings.SplitN(token, "=", 2)
+		name, value := strings.ToUpper(parts[0]), ""
+		if len(parts) > 1 {
+			value = unescape(parts[1])
+		}
+		support.tokens[name] = value
+		support.apply(name, value, false)
+	}
+}
+
+// apply updates the parsed value of the given token. If reset is true, the
+// server has removed the token and the default is restored.
+func (support *Support) apply(name, value string, reset bool) {
+	switch name {
+	case "PREFIX":
+		if reset {
+			value = DefaultPrefix
+		}
+		support.parsePrefix(value)
+	case "CHANTYPES":
+		if reset {
+			value = DefaultChanTypes
+		}
+		support.chanTypes = value
+	case "CHANMODES":
+		if reset {
+			value = DefaultChanModes
+		}
+		support.parseChanModes(value)
+	case "CASEMAPPING":
+		if reset || len(value) == 0 {
+			support.caseMapping = casemap.Default
+		} else {
+			support.caseMapping = casemap.Mapping(strings.ToLower(value))
+		}
+	case "NICKLEN":
+		support.nickLen, _ = strconv.Atoi(value)
+	case "TOPICLEN":
+		support.topicLen, _ = strconv.Atoi(value)
+	case "MODES":
+		if reset {
+			support.modes = DefaultModes
+		} else if len(value) == 0 {
+			// No value means there's no limit
+			support.modes = 0
+		} else {
+			support.modes, _ = strconv.Atoi(value)
+		}
+	}
+}
+
+// parsePrefix parses a PREFIX value such as (ov)@+. An empty value means no prefixes.
+func (support *Support) parsePrefix(value string) {
+	support.prefixModes, support.prefixes = "", ""
+	if len(value) == 0 || value[0] != '(' {
+		return
+	}
+	end := strings.IndexByte(value, ')')
+	if end < 0 || len(value)-end-1 != end-1 {
+		return
+	}
+	support.prefixModes = value[1:end]
+	support.prefixes = value[end+1:]
+}
+
+func (support *Support) parseChanModes(value string) {
+	support.chanModes = [4]string{}
+	for i, modes := range strings.SplitN(value, ",", 4) {
+		support.chanModes[i] = modes
+	}
+}
+
+// unescape decodes the \xHH escapes in token values
+func unescape(value string) string {
+	if !strings.Contains(value, "\\x") {
+		return value
+	}
+	var buf = make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if b, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				buf = append(buf, byte(b))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, value[i])
+	}
+	return string(buf)
+}
+
+// Get gets the raw value of the given token and whether the server advertised it
+func (support *Support) Get(name string) (string, bool) {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	value, ok := support.tokens[strings.ToUpper(name)]
+	return value, ok
+}
+
+// Tokens gets a copy of all the advertised tokens
+func (support *Support) Tokens() map[string]string {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	var tokens = make(map[string]string, len(support.tokens))
+	for name, value := range support.tokens {
+		tokens[name] = value
+	}
+	return tokens
+}
+
+// IsChannel checks if the given target is a channel name
+func (support *Support) IsChannel(target string) bool {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return len(target) > 0 && strings.IndexByte(support.chanTypes, target[0]) >= 0
+}
+
+// StripStatusPrefix removes the prefix from a STATUSMSG target like @#channel,
+// which only reaches the users with the given prefix in the channel.
+func (support *Support) StripStatusPrefix(target string) string {
+	support.lock.RLock()
+	statusMsg, ok := support.tokens["STATUSMSG"]
+	if !ok {
+		statusMsg = support.prefixes
+	}
+	support.lock.RUnlock()
+	if len(target) > 1 && strings.IndexByte(statusMsg, target[0]) >= 0 && support.IsChannel(target[1:]) {
+		return target[1:]
+	}
+	return target
+}
+
+// Prefixes gets the nick prefixes from the highest to the lowest, e.g. ~&@%+
+func (support *Support) Prefixes() string {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return support.prefixes
+}
+
+// PrefixModes gets the modes of the nick prefixes from the highest to the lowest, e.g. qaohv
+func (support *Support) PrefixModes() string {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return support.prefixModes
+}
+
+// PrefixOfMode gets the nick prefix the given mode gives, or 0 if it isn't a prefix mode
+func (support *Support) PrefixOfMode(mode rune) rune {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	i := strings.IndexRune(support.prefixModes, mode)
+	if i < 0 || i >= len(support.prefixes) {
+		return 0
+	}
+	return rune(support.prefixes[i])
+}
+
+// ModeOfPrefix gets the mode that gives the given nick prefix, or 0 if it isn't a prefix
+func (support *Support) ModeOfPrefix(prefix rune) rune {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	i := strings.IndexRune(support.prefixes, prefix)
+	if i < 0 || i >= len(support.prefixModes) {
+		return 0
+	}
+	return rune(support.prefixModes[i])
+}
+
+// ModeType gets the type of the given channel mode
+func (support *Support) ModeType(mode rune) int {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	if strings.IndexRune(support.prefixModes, mode) >= 0 {
+		return ModePrefix
+	}
+	for typ, modes := range support.chanModes {
+		if strings.IndexRune(modes, mode) >= 0 {
+			return typ
+		}
+	}
+	return ModeUnknown
+}
+
+// TakesParam checks if the given channel mode takes a parameter when it's set or unset
+func (support *Support) TakesParam(mode rune, set bool) bool {
+	switch support.ModeType(mode) {
+	case ModeList, ModeAlways, ModePrefix:
+		return true
+	case ModeSet:
+		return set
+	default:
+		return false
+	}
+}
+
+// CaseMapping gets the casemapping of the server
+func (support *Support) CaseMapping() casemap.Mapping {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return support.caseMapping
+}
+
+// NickLen gets the maximum length of nicks, or 0 if it's unknown
+func (support *Support) NickLen() int {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return support.nickLen
+}
+
+// TopicLen gets the maximum length of topics, or 0 if it's unknown
+func (support *Support) TopicLen() int {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return support.topicLen
+}
+
+// Modes gets the maximum number of parameter modes in a single MODE command, or 0 if there's no limit
+func (support *Support) Modes() int {
+	support.lock.RLock()
+	defer support.lock.RUnlock()
+	return support.modes
+}
+
+// ModeChange contains the mode string and parameters of a single MODE command
+type ModeChange struct {
+	Modes string
+	Args  []string
+}
+
+// SplitModes splits a channel mode change into MODE commands that each have at
+// most as many parameter modes as the server allows. Modes are kept in order.
+func (support *Support) SplitModes(modes string, args []string) (changes []ModeChange) {
+	limit := support.Modes()
+	var current ModeChange
+	var currentSign rune
+	var params int
+	set := true
+	for _, mode := range modes {
+		if mode == '+' || mode == '-' {
+			set = mode == '+'
+			continue
+		}
+
+		var arg string
+		hasArg := support.TakesParam(mode, set) && len(args) > 0
+		if hasArg {
+			arg, args = args[0], args[1:]
+			if limit > 0 && params == limit {
+				changes = append(changes, current)
+				current, currentSign, params = ModeChange{}, 0, 0
+			}
+			params++
+		}
+
+		sign := '-'
+		if set {
+			sign = '+'
+		}
+		if sign != currentSign {
+			current.Modes += string(sign)
+			currentSign = sign
+		}
+		current.Modes += string(mode)
+		if hasArg {
+			current.Args = append(current.Args, arg)
+		}
+	}
+	if len(current.Modes) > 0 {
+		current.Args = append(current.Args, args...)
+		changes = append(changes, current)
+	}
+	return
+}
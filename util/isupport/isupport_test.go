@@ -0,0 +1,56 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package isupport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokens   []string
+		modes    string
+		args     []string
+		expected []ModeChange
+	}{
+		{"split at limit", []string{"MODES=2"}, "+ooo-v+ntl", []string{"a", "b", "c", "d", "5"}, []ModeChange{
+			{"+oo", []string{"a", "b"}},
+			{"+o-v+nt", []string{"c", "d"}},
+			{"+l", []string{"5"}},
+		}},
+		{"list mode without argument", []string{"MODES=2"}, "+b", nil, []ModeChange{
+			{"+b", nil},
+		}},
+		{"no limit", []string{"MODES"}, "+ooo-k", []string{"a", "b", "c", "key"}, []ModeChange{
+			{"+ooo-k", []string{"a", "b", "c", "key"}},
+		}},
+		{"limit unset takes no parameter", []string{"MODES=1"}, "-l+k", []string{"key"}, []ModeChange{
+			{"-l+k", []string{"key"}},
+		}},
+		{"empty", nil, "", nil, nil},
+	}
+	for _, test := range tests {
+		support := New()
+		support.Parse(test.tokens)
+		changes := support.SplitModes(test.modes, test.args)
+		if !reflect.DeepEqual(changes, test.expected) {
+			t.Errorf("%s: SplitModes(%q, %q) = %v, expected %v", test.name, test.modes, test.args, changes, test.expected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package jsonschema generates JSON Schemas for Go types
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema
+type Schema map[string]interface{}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+var timeType = reflect.TypeOf(time.Time{})
+
+// Generator generates schemas. Named struct types are collected into Definitions
+// and referenced from the schemas that use them.
+type Generator struct {
+	Definitions map[string]Schema
+	names       map[reflect.Type]string
+}
+
+// NewGenerator creates a new schema generator
+func NewGenerator() *Generator {
+	return &Generator{Definitions: make(map[string]Schema), names: make(map[reflect.Type]string)}
+}
+
+// Of generates the schema of the type of the given value
+func (gen *Generator) Of(val interface{}) Schema {
+	return gen.schema(reflect.TypeOf(val))
+}
+
+// Ref returns a schema that references the given definition
+func Ref(name string) Schema {
+	return Schema{"$ref": "#/definitions/" + name}
+}
+
+func (gen *Generator) schema(t reflect.Type) Schema {
+	if t == nil || t == rawMessageType {
+		return Schema{}
+	} else if t == timeType {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return gen.schema(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": gen.schema(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": gen.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return gen.object(t)
+		}
+		return Ref(gen.define(t))
+	default:
+		return Schema{}
+	}
+}
+
+// define adds the given named struct type to the definitions and returns its name
+func (gen *Generator) define(t reflect.Type) string {
+	if name, ok := gen.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := gen.Definitions[name]; taken {
+		parts := strings.Split(t.PkgPath(), "/")
+		name = parts[len(parts)-1] + "." + name
+	}
+	gen.names[t] = name
+	// Add a placeholder first in case the type refers to itself
+	gen.Definitions[name] = Schema{}
+	gen.Definitions[name] = gen.object(t)
+	return name
+}
+
+func (gen *Generator) object(t reflect.Type) Schema {
+	properties := make(map[string]interface{})
+	var required []string
+	gen.fields(t, properties, &required)
+
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fields adds the fields of the given struct type to the properties the same
+// way encoding/json would encode them. Fields without omitempty are required.
+func (gen *Generator) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		if field.Anonymous && len(name) == 0 {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				gen.fields(embedded, properties, required)
+				continue
+			}
+		}
+		if len(field.PkgPath) > 0 {
+			// Unexported field
+			continue
+		} else if len(name) == 0 {
+			name = field.Name
+		}
+
+		properties[name] = gen.schema(field.Type)
+		omitempty := false
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				omitempty = true
+			}
+		}
+		if !omitempty {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package logimport parses the logs of other IRC clients and bouncers into messages
+package logimport
+
+import (
+	"io"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
+)
+
+// BatchSize is the number of messages inserted into the database at once
+const BatchSize = 500
+
+// Progress contains the status of an import
+type Progress struct {
+	File       string `json:"file,omitempty"`
+	Imported   int    `json:"imported"`
+	Duplicates int    `json:"duplicates"`
+	Skipped    int    `json:"skipped"`
+}
+
+// Importer imports logs into the history of an user
+type Importer struct {
+	Email string
+	// Progress is called after every batch of messages. Optional.
+	Progress func(Progress)
+
+	status Progress
+	maxID  int64
+	batch  []*database.Insertion
+}
+
+// NewImporter creates an importer for the given user. Only messages that are
+// already in the history when the importer is created count as duplicates, so
+// identical lines in the imported logs are all kept.
+func NewImporter(email string) (*Importer, error) {
+	maxID, err := database.LastID(email)
+	if err != nil {
+		return nil, err
+	}
+	return &Importer{Email: email, maxID: maxID}, nil
+}
+
+// Status gets the total progress of the importer
+func (imp *Importer) Status() Progress {
+	return imp.status
+}
+
+// Import parses the given log and inserts its messages into the history
+func (imp *Importer) Import(format string, r io.Reader, opts Options) error {
+	imp.status.File = opts.FileName
+	skipped, err := Parse(format, r, opts, imp.add)
+	imp.status.Skipped += skipped
+	if err != nil {
+		return err
+	}
+	return imp.flush()
+}
+
+func (imp *Importer) add(msg messages.Message) error {
+	imp.batch = append(imp.batch, &database.Insertion{Email: imp.Email, Message: msg})
+	if len(imp.batch) >= BatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *Importer) flush() error {
+	if imp.maxID > 0 && len(imp.batch) > 0 {
+		err := imp.removeDuplicates()
+		if err != nil {
+			return err
+		}
+	}
+	if len(imp.batch) > 0 {
+		err := database.InsertBatch(imp.batch)
+		if err != nil {
+			return err
+		}
+		imp.status.Imported += len(imp.batch)
+		imp.batch = imp.batch[:0]
+	}
+	if imp.Progress != nil {
+		imp.Progress(imp.status)
+	}
+	return nil
+}
+
+// messageKey contains the fields that are compared to find duplicate messages
+type messageKey struct {
+	Timestamp                int64
+	Sender, Command, Message string
+}
+
+func keyOf(msg messages.Message) messageKey {
+	return messageKey{msg.Timestamp, msg.Sender, msg.Command, msg.Message}
+}
+
+// removeDuplicates removes the messages that are already in the history from
+// the batch. The history of each channel in the batch is loaded with a single
+// query covering the time range of the batch.
+func (imp *Importer) removeDuplicates() error {
+	var queries = make(map[[2]string]*database.HistoryQuery)
+	for _, ins := range imp.batch {
+		msg := ins.Message
+		query, ok := queries[[2]string{msg.Network, msg.Channel}]
+		if !ok {
+			query = &database.HistoryQuery{Network: msg.Network, Channel: msg.Channel, Before: imp.maxID + 1, Since: msg.Timestamp, Until: msg.Timestamp}
+			queries[[2]string{msg.Network, msg.Channel}] = query
+		} else if msg.Timestamp < query.Since {
+			query.Since = msg.Timestamp
+		} else if msg.Timestamp > query.Until {
+			query.Until = msg.Timestamp
+		}
+	}
+
+	var existing = make(map[[2]string]map[messageKey]bool, len(queries))
+	for channel, query := range queries {
+		history, err := database.QueryHistory(imp.Email, *query)
+		if err != nil {
+			return err
+		}
+		keys := make(map[messageKey]bool, len(history))
+		for _, msg := range history {
+			keys[keyOf(msg)] = true
+		}
+		existing[channel] = keys
+	}
+
+	var batch = imp.batch[:0]
+	for _, ins := range imp.batch {
+		if existing[[2]string{ins.Message.Network, ins.Message.Channel}][keyOf(ins.Message)] {
+			imp.status.Duplicates++
+			continue
+		}
+		batch = append(batch, ins)
+	}
+	imp.batch = batch
+	return nil
+}
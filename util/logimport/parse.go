@@ -0,0 +1,278 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package logimport parses the logs of other IRC clients and bouncers into messages
+package logimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+// Supported log formats
+const (
+	FormatZNC     = "znc"
+	FormatIrssi   = "irssi"
+	FormatWeeChat = "weechat"
+)
+
+// Options contains the information that isn't included in the log lines
+type Options struct {
+	// Network and Channel are the network and channel the messages are imported into.
+	Network string
+	Channel string
+	// Nick is the nick of the user. Messages sent by it are marked as own messages.
+	Nick string
+	// Location is the time zone the log timestamps are in. Defaults to the local time zone.
+	Location *time.Location
+	// Date is the date of the log file. Only used by the ZNC format, which only
+	// has times in the log lines. If zero, the date is read from the file name.
+	Date time.Time
+	// FileName is the name of the log file.
+	FileName string
+}
+
+// line is a log line pattern and the function that converts its submatches
+// into the sender, command and message of a message
+type line struct {
+	pattern *regexp.Regexp
+	parse   func(match []string) (sender, command, message string)
+}
+
+func simple(command string) func(match []string) (string, string, string) {
+	return func(match []string) (string, string, string) {
+		var message string
+		if len(match) > 2 {
+			message = match[2]
+		}
+		return match[1], command, message
+	}
+}
+
+func kick(match []string) (string, string, string) {
+	return match[2], "kick", match[1] + ":" + match[3]
+}
+
+func nickChange(match []string) (string, string, string) {
+	return match[1], "nick", match[2]
+}
+
+func modeChange(match []string) (string, string, string) {
+	return match[2], "mode", match[1]
+}
+
+var dateInName = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+
+var zncTime = regexp.MustCompile(`^\[(\d{2}):(\d{2}):(\d{2})\] (.*)$`)
+var zncLines = []line{
+	{regexp.MustCompile(`^<([^>]+)> (.*)$`), simple("privmsg")},
+	{regexp.MustCompile(`^\*\*\* Joins: (\S+)`), simple("join")},
+	{regexp.MustCompile(`^\*\*\* Parts: (\S+) \([^)]*\)(?: \((.*)\))?$`), simple("part")},
+	{regexp.MustCompile(`^\*\*\* Quits: (\S+) \([^)]*\)(?: \((.*)\))?$`), simple("quit")},
+	{regexp.MustCompile(`^\*\*\* (\S+) was kicked by (\S+) \((.*)\)$`), kick},
+	{regexp.MustCompile(`^\*\*\* (\S+) is now known as (\S+)$`), nickChange},
+	{regexp.MustCompile(`^\*\*\* (\S+) sets mode: (.*)$`), simple("mode")},
+	{regexp.MustCompile(`^\*\*\* (\S+) changes topic to '(.*)'$`), simple("topic")},
+	{regexp.MustCompile(`^\* (\S+) (.*)$`), simple("action")},
+	{regexp.MustCompile(`^-([^ -]+)- (.*)$`), simple("notice")},
+}
+
+var irssiTime = regexp.MustCompile(`^(\d{2}):(\d{2})(?::(\d{2}))? (.*)$`)
+var irssiLogOpened = regexp.MustCompile(`^--- Log opened (.*)$`)
+var irssiDayChanged = regexp.MustCompile(`^--- Day changed (.*)$`)
+var irssiLines = []line{
+	{regexp.MustCompile(`^<[ @+%&~]?([^>]+)> (.*)$`), simple("privmsg")},
+	{regexp.MustCompile(`^ ?\* (\S+) (.*)$`), simple("action")},
+	{regexp.MustCompile(`^-!- (\S+) \[[^\]]*\] has joined`), simple("join")},
+	{regexp.MustCompile(`^-!- (\S+) \[[^\]]*\] has left \S+(?: \[(.*)\])?$`), simple("part")},
+	{regexp.MustCompile(`^-!- (\S+) \[[^\]]*\] has quit(?: \[(.*)\])?$`), simple("quit")},
+	{regexp.MustCompile(`^-!- (\S+) was kicked from \S+ by (\S+) \[(.*)\]$`), kick},
+	{regexp.MustCompile(`^-!- (\S+) is now known as (\S+)$`), nickChange},
+	{regexp.MustCompile(`^-!- mode/\S+ \[(.*)\] by (\S+)$`), modeChange},
+	{regexp.MustCompile(`^-!- (\S+) changed the topic of \S+ to: (.*)$`), simple("topic")},
+	{regexp.MustCompile(`^-([^ (:-]+)(?:\([^)]*\))?(?::\S+)?- (.*)$`), simple("notice")},
+}
+
+var weechatLine = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\t([^\t]*)\t(.*)$`)
+var weechatEvents = map[string][]line{
+	"-->": {
+		{regexp.MustCompile(`^(\S+) \([^)]*\) has joined`), simple("join")},
+	},
+	"<--": {
+		{regexp.MustCompile(`^(\S+) \([^)]*\) has left \S+(?: \((.*)\))?$`), simple("part")},
+		{regexp.MustCompile(`^(\S+) \([^)]*\) has quit(?: \((.*)\))?$`), simple("quit")},
+		{regexp.MustCompile(`^(\S+) has kicked (\S+)(?: \((.*)\))?$`), func(match []string) (string, string, string) {
+			return match[1], "kick", match[2] + ":" + match[3]
+		}},
+	},
+	"--": {
+		{regexp.MustCompile(`^(\S+) is now known as (\S+)$`), nickChange},
+		{regexp.MustCompile(`^Mode \S+ \[(.*)\] by (\S+)$`), modeChange},
+		{regexp.MustCompile(`^(\S+) has changed topic for \S+(?: from ".*")? to "(.*)"$`), simple("topic")},
+		{regexp.MustCompile(`^Notice\((\S+)\)(?: -> \S+)?: (.*)$`), simple("notice")},
+	},
+	"*": {
+		{regexp.MustCompile(`^(\S+) (.*)$`), simple("action")},
+	},
+}
+
+// parseBody matches the given log line body against the given patterns
+func parseBody(body string, lines []line) (sender, command, message string, ok bool) {
+	for _, l := range lines {
+		match := l.pattern.FindStringSubmatch(body)
+		if match != nil {
+			sender, command, message = l.parse(match)
+			return sender, command, message, true
+		}
+	}
+	return
+}
+
+func atoi(str string) int {
+	i, _ := strconv.Atoi(str)
+	return i
+}
+
+// Parse reads the log in the given format and calls the given function for
+// every message. Lines that can't be parsed are skipped and counted.
+func Parse(format string, r io.Reader, opts Options, do func(msg messages.Message) error) (skipped int, err error) {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+
+	var parseLine func(text string) (messages.Message, bool)
+	switch strings.ToLower(format) {
+	case FormatZNC:
+		parseLine, err = zncParser(opts)
+	case FormatIrssi:
+		parseLine = irssiParser(opts)
+	case FormatWeeChat:
+		parseLine = weechatParser(opts)
+	default:
+		err = fmt.Errorf("Unknown log format %s", format)
+	}
+	if err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(text) == 0 {
+			continue
+		}
+		msg, ok := parseLine(text)
+		if !ok {
+			// Lines starting with --- are log metadata like irssi's day changes
+			if !strings.HasPrefix(text, "--- ") {
+				skipped++
+			}
+			continue
+		}
+		msg.Network = opts.Network
+		msg.Channel = opts.Channel
+		msg.OwnMsg = len(opts.Nick) > 0 && msg.Sender == opts.Nick
+		if err = do(msg); err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+func zncParser(opts Options) (func(text string) (messages.Message, bool), error) {
+	date := opts.Date
+	if date.IsZero() {
+		match := dateInName.FindStringSubmatch(filepath.Base(opts.FileName))
+		if match == nil {
+			return nil, fmt.Errorf("ZNC logs need a date, but the file name %s doesn't contain one", opts.FileName)
+		}
+		date = time.Date(atoi(match[1]), time.Month(atoi(match[2])), atoi(match[3]), 0, 0, 0, 0, opts.Location)
+	}
+	year, month, day := date.Date()
+
+	return func(text string) (msg messages.Message, ok bool) {
+		match := zncTime.FindStringSubmatch(text)
+		if match == nil {
+			return
+		}
+		msg.Timestamp = messages.UnixMilli(time.Date(year, month, day, atoi(match[1]), atoi(match[2]), atoi(match[3]), 0, opts.Location))
+		msg.Sender, msg.Command, msg.Message, ok = parseBody(match[4], zncLines)
+		return
+	}, nil
+}
+
+func irssiParser(opts Options) func(text string) (messages.Message, bool) {
+	var date time.Time
+	return func(text string) (msg messages.Message, ok bool) {
+		if match := irssiLogOpened.FindStringSubmatch(text); match != nil {
+			if t, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", match[1], opts.Location); err == nil {
+				date = t
+			}
+			return
+		} else if match = irssiDayChanged.FindStringSubmatch(text); match != nil {
+			if t, err := time.ParseInLocation("Mon Jan _2 2006", match[1], opts.Location); err == nil {
+				date = t
+			}
+			return
+		} else if date.IsZero() {
+			return
+		}
+
+		match := irssiTime.FindStringSubmatch(text)
+		if match == nil {
+			return
+		}
+		year, month, day := date.Date()
+		msg.Timestamp = messages.UnixMilli(time.Date(year, month, day, atoi(match[1]), atoi(match[2]), atoi(match[3]), 0, opts.Location))
+		msg.Sender, msg.Command, msg.Message, ok = parseBody(match[4], irssiLines)
+		return
+	}
+}
+
+func weechatParser(opts Options) func(text string) (messages.Message, bool) {
+	return func(text string) (msg messages.Message, ok bool) {
+		match := weechatLine.FindStringSubmatch(text)
+		if match == nil {
+			return
+		}
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", match[1], opts.Location)
+		if err != nil {
+			return
+		}
+		msg.Timestamp = messages.UnixMilli(t)
+
+		prefix := strings.TrimSpace(match[2])
+		if lines, isEvent := weechatEvents[prefix]; isEvent {
+			msg.Sender, msg.Command, msg.Message, ok = parseBody(match[3], lines)
+		} else if len(prefix) > 0 {
+			msg.Sender = strings.TrimLeft(prefix, "@+%&~")
+			msg.Command = "privmsg"
+			msg.Message = match[3]
+			ok = len(msg.Sender) > 0
+		}
+		return
+	}
+}
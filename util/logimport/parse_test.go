@@ -0,0 +1,142 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package logimport
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+var testDate = time.Date(2016, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// testMessage creates the message that is expected to be parsed from a test log line sent the given duration after testDate
+func testMessage(after time.Duration, sender, command, message string) messages.Message {
+	return messages.Message{
+		Network:   "net",
+		Channel:   "#chan",
+		Timestamp: messages.UnixMilli(testDate.Add(after)),
+		Sender:    sender,
+		Command:   command,
+		Message:   message,
+		OwnMsg:    sender == "me",
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		format   string
+		fileName string
+		log      string
+		expected []messages.Message
+		skipped  int
+	}{
+		{FormatZNC, "#chan_20160102.log", `[12:00:01] <me> hello
+[12:00:02] * bob waves
+[12:00:03] *** Joins: bob (b@host)
+[12:00:04] *** Quits: bob (b@host) (Quit: x)
+[12:00:05] *** bob was kicked by op (spam)
+[12:00:06] *** op sets mode: +o bob
+[12:00:07] *** bob is now known as rob
+[12:00:08] *** op changes topic to 'new topic'
+[12:00:09] -NickServ- hi
+garbage`, []messages.Message{
+			testMessage(1*time.Second, "me", "privmsg", "hello"),
+			testMessage(2*time.Second, "bob", "action", "waves"),
+			testMessage(3*time.Second, "bob", "join", ""),
+			testMessage(4*time.Second, "bob", "quit", "Quit: x"),
+			testMessage(5*time.Second, "op", "kick", "bob:spam"),
+			testMessage(6*time.Second, "op", "mode", "+o bob"),
+			testMessage(7*time.Second, "bob", "nick", "rob"),
+			testMessage(8*time.Second, "op", "topic", "new topic"),
+			testMessage(9*time.Second, "NickServ", "notice", "hi"),
+		}, 1},
+		{FormatIrssi, "", `12:00 <op> before the log was opened
+--- Log opened Sat Jan 02 12:00:00 2016
+12:00 <@op> hello
+12:01  * bob waves
+12:02 -!- bob [b@host] has left #chan [bye]
+12:03 -!- bob was kicked from #chan by op [spam]
+--- Day changed Sun Jan 03 2016
+00:01:02 < me> late
+`, []messages.Message{
+			testMessage(0, "op", "privmsg", "hello"),
+			testMessage(1*time.Minute, "bob", "action", "waves"),
+			testMessage(2*time.Minute, "bob", "part", "bye"),
+			testMessage(3*time.Minute, "op", "kick", "bob:spam"),
+			testMessage(12*time.Hour+1*time.Minute+2*time.Second, "me", "privmsg", "late"),
+		}, 1},
+		{FormatWeeChat, "", "2016-01-02 12:00:00\t@op\thello\n" +
+			"2016-01-02 12:00:01\t-->\tbob (b@host) has joined #chan\n" +
+			"2016-01-02 12:00:02\t<--\tbob (b@host) has quit (x)\n" +
+			"2016-01-02 12:00:03\t--\tMode #chan [+o bob] by op\n" +
+			"2016-01-02 12:00:04\t *\tbob waves\n" +
+			"2016-01-02 12:00:05\t--\top has changed topic for #chan from \"a\" to \"b\"\n" +
+			"not a weechat line\n", []messages.Message{
+			testMessage(0, "op", "privmsg", "hello"),
+			testMessage(1*time.Second, "bob", "join", ""),
+			testMessage(2*time.Second, "bob", "quit", "x"),
+			testMessage(3*time.Second, "op", "mode", "+o bob"),
+			testMessage(4*time.Second, "bob", "action", "waves"),
+			testMessage(5*time.Second, "op", "topic", "b"),
+		}, 1},
+	}
+	for _, test := range tests {
+		var parsed []messages.Message
+		opts := Options{Network: "net", Channel: "#chan", Nick: "me", Location: time.UTC, FileName: test.fileName}
+		skipped, err := Parse(test.format, strings.NewReader(test.log), opts, func(msg messages.Message) error {
+			parsed = append(parsed, msg)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.format, err)
+			continue
+		}
+		if skipped != test.skipped {
+			t.Errorf("%s: expected %d skipped lines, got %d", test.format, test.skipped, skipped)
+		}
+		if len(parsed) != len(test.expected) {
+			t.Errorf("%s: expected %d messages, got %d", test.format, len(test.expected), len(parsed))
+			continue
+		}
+		for i := range parsed {
+			if !reflect.DeepEqual(parsed[i], test.expected[i]) {
+				t.Errorf("%s: message %d is %+v, expected %+v", test.format, i, parsed[i], test.expected[i])
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, format, fileName string
+	}{
+		{"unknown format", "mirc", "#chan.log"},
+		{"znc without date", FormatZNC, "#chan.log"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.format, strings.NewReader(""), Options{FileName: test.fileName}, func(msg messages.Message) error {
+			return nil
+		})
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
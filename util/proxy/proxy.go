@@ -0,0 +1,96 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package proxy contains SOCKS5 and HTTP CONNECT proxy dialers
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Proxy types
+const (
+	SOCKS5 = "socks5"
+	HTTP   = "http"
+	// Tor is a SOCKS5 proxy that defaults to the local Tor daemon
+	Tor = "tor"
+)
+
+// DefaultTorAddress is the address of the SOCKS port of a local Tor daemon
+const DefaultTorAddress = "127.0.0.1:9050"
+
+// HandshakeTimeout is the time the proxy has to open the connection to the target
+const HandshakeTimeout = 30 * time.Second
+
+// Dialer opens connections
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// Config contains the settings of a proxy
+type Config struct {
+	Type     string
+	Address  string
+	Username string
+	Password string
+}
+
+// Host gets the hostname or IP of the proxy server
+func (config Config) Host() string {
+	address := config.Address
+	if len(address) == 0 && strings.ToLower(config.Type) == Tor {
+		address = DefaultTorAddress
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// New creates a dialer that connects through the given proxy. The connection
+// to the proxy itself is opened with the forward dialer.
+func New(config Config, forward Dialer) (Dialer, error) {
+	switch strings.ToLower(config.Type) {
+	case SOCKS5:
+		if len(config.Address) == 0 {
+			return nil, fmt.Errorf("The proxy address is missing")
+		}
+		return &socks5{config: config, forward: forward}, nil
+	case Tor:
+		if len(config.Address) == 0 {
+			config.Address = DefaultTorAddress
+		}
+		// Tor resolves hostnames itself, so onion services work as long as the name isn't resolved locally
+		return &socks5{config: config, forward: forward}, nil
+	case HTTP:
+		if len(config.Address) == 0 {
+			return nil, fmt.Errorf("The proxy address is missing")
+		}
+		return &httpConnect{config: config, forward: forward}, nil
+	default:
+		return nil, fmt.Errorf("Unknown proxy type %s", config.Type)
+	}
+}
+
+// IsOnion checks if the given host is a Tor onion service. Onion services can
+// only be reached through Tor, which must also resolve the name.
+func IsOnion(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".onion")
+}
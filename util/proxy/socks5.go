@@ -0,0 +1,180 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package proxy contains SOCKS5 and HTTP CONNECT proxy dialers
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socks5 is a SOCKS5 (RFC 1928) proxy dialer with optional username/password authentication (RFC 1929)
+type socks5 struct {
+	config  Config
+	forward Dialer
+}
+
+const (
+	socksVersion      = 5
+	socksAuthNone     = 0
+	socksAuthPassword = 2
+	socksAuthNoMatch  = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+)
+
+var socksErrors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+func (proxy *socks5) Dial(network, address string) (net.Conn, error) {
+	conn, err := proxy.forward.Dial("tcp", proxy.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	err = proxy.handshake(conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %s", proxy.config.Address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (proxy *socks5) handshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", portStr)
+	}
+
+	methods := []byte{socksAuthNone}
+	if len(proxy.config.Username) > 0 {
+		methods = append(methods, socksAuthPassword)
+	}
+	_, err = conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	} else if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected protocol version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err = proxy.authenticate(conn); err != nil {
+			return err
+		}
+	case socksAuthNoMatch:
+		return errors.New("no acceptable authentication methods")
+	default:
+		return fmt.Errorf("unexpected authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		// Hostnames are resolved by the proxy, which is required for onion services
+		if len(host) > 255 {
+			return errors.New("hostname too long")
+		}
+		req = append(req, socksDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socksIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socksIPv6)
+		req = append(req, ip...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return err
+	} else if header[1] != 0 {
+		if int(header[1]) < len(socksErrors) {
+			return errors.New(socksErrors[header[1]])
+		}
+		return fmt.Errorf("unknown error %d", header[1])
+	}
+
+	// Skip the bound address
+	var skip int
+	switch header[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+func (proxy *socks5) authenticate(conn net.Conn) error {
+	user, pass := proxy.config.Username, proxy.config.Password
+	if len(user) > 255 || len(pass) > 255 {
+		return errors.New("username or password too long")
+	}
+	req := []byte{1, byte(len(user))}
+	req = append(req, user...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	} else if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
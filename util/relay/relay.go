@@ -0,0 +1,131 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package relay contains a loopback relay that lets the IRC library use connections opened by mauIRC
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// AcceptTimeout is how long the relay waits for the IRC library to connect
+const AcceptTimeout = 10 * time.Second
+
+// Relay forwards lines between a connection to an IRC server and the
+// connection the IRC library opens to the loopback listener of the relay.
+// This lets mauIRC dial the server with its own TLS, proxy and bind settings.
+type Relay struct {
+	listener *net.TCPListener
+	filter   func(line string) string
+
+	server, client net.Conn
+	closeOnce      sync.Once
+}
+
+// Listen opens the loopback listener of a new relay. The lines received from
+// the server are passed through the filter before they're forwarded to the
+// IRC library. The filter is optional.
+func Listen(filter func(line string) string) (*Relay, error) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	return &Relay{listener: listener, filter: filter}, nil
+}
+
+// IP gets the address the IRC library must connect to
+func (relay *Relay) IP() string {
+	return relay.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port gets the port the IRC library must connect to
+func (relay *Relay) Port() uint16 {
+	return uint16(relay.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Start accepts the connection the IRC library opened from the given local
+// address and starts relaying it to the server. Other connections to the
+// listener are rejected. The listener is closed in any case.
+func (relay *Relay) Start(server net.Conn, client net.Addr) error {
+	defer relay.listener.Close()
+	relay.listener.SetDeadline(time.Now().Add(AcceptTimeout))
+	for {
+		conn, err := relay.listener.AcceptTCP()
+		if err != nil {
+			return fmt.Errorf("The IRC library didn't connect to the relay: %s", err)
+		} else if conn.RemoteAddr().String() != client.String() {
+			conn.Close()
+			continue
+		}
+
+		relay.server, relay.client = server, conn
+		go relay.forward()
+		go relay.backward()
+		return nil
+	}
+}
+
+// Close the listener and both connections
+func (relay *Relay) Close() {
+	relay.listener.Close()
+	relay.closeOnce.Do(func() {
+		if relay.server != nil {
+			relay.server.Close()
+		}
+		if relay.client != nil {
+			relay.client.Close()
+		}
+	})
+}
+
+// forward copies the lines from the server to the IRC library
+func (relay *Relay) forward() {
+	defer relay.Close()
+	reader := bufio.NewReader(relay.server)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if relay.filter != nil {
+				line = relay.filter(trimLine(line)) + "\r\n"
+			}
+			if _, werr := io.WriteString(relay.client, line); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// backward copies everything the IRC library sends to the server
+func (relay *Relay) backward() {
+	defer relay.Close()
+	io.Copy(relay.server, relay.client)
+}
+
+// trimLine removes the line ending from the given line
+func trimLine(line string) string {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -0,0 +1,86 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package relay
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRelay(t *testing.T) {
+	serverSide, server := net.Pipe()
+	defer serverSide.Close()
+
+	rel, err := Listen(strings.ToUpper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rel.Close()
+	address := rel.listener.Addr().String()
+
+	// A connection from another address is rejected
+	other, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	client, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err = rel.Start(server, client.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	go serverSide.Write([]byte(":server PING :abc\r\n"))
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	} else if line != ":SERVER PING :ABC\r\n" {
+		t.Errorf("Expected the filtered line, got %q", line)
+	}
+
+	go client.Write([]byte("PONG :abc\r\n"))
+	line, err = bufio.NewReader(serverSide).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	} else if line != "PONG :abc\r\n" {
+		t.Errorf("Expected the line from the client unchanged, got %q", line)
+	}
+
+	if _, err = other.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the connection from another address to be closed")
+	}
+}
+
+func TestTrimLine(t *testing.T) {
+	tests := map[string]string{
+		"PING :a\r\n": "PING :a",
+		"PING :a\n":   "PING :a",
+		"PING :a":     "PING :a",
+		"\r\n":        "",
+	}
+	for input, expected := range tests {
+		if output := trimLine(input); output != expected {
+			t.Errorf("trimLine(%q) = %q, expected %q", input, output, expected)
+		}
+	}
+}
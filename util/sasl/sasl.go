@@ -0,0 +1,134 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package sasl contains the SASL mechanisms used to authenticate to IRC networks
+package sasl
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Mechanism names
+const (
+	Plain       = "PLAIN"
+	External    = "EXTERNAL"
+	ScramSHA256 = "SCRAM-SHA-256"
+)
+
+// ChunkSize is the maximum length of a single AUTHENTICATE payload
+const ChunkSize = 400
+
+// Mechanism is a client-side SASL mechanism
+type Mechanism interface {
+	// Name gets the name of the mechanism
+	Name() string
+	// Next gets the response to the given server challenge. The first
+	// challenge is always empty.
+	Next(challenge []byte) ([]byte, error)
+}
+
+// New creates a mechanism with the given name and credentials
+func New(name, username, password string) (Mechanism, error) {
+	switch strings.ToUpper(name) {
+	case Plain:
+		return &plain{username: username, password: password}, nil
+	case External:
+		return &external{}, nil
+	case ScramSHA256:
+		return &scram{username: username, password: password}, nil
+	default:
+		return nil, fmt.Errorf("Unknown SASL mechanism %s", name)
+	}
+}
+
+// Supported checks if the mechanism with the given name is in the
+// comma-separated list the server advertised. An empty list means that the
+// server didn't advertise its mechanisms.
+func Supported(name, list string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, mech := range strings.Split(list, ",") {
+		if strings.EqualFold(mech, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode encodes the given response into AUTHENTICATE payloads
+func Encode(response []byte) []string {
+	if len(response) == 0 {
+		return []string{"+"}
+	}
+
+	var payloads []string
+	encoded := base64.StdEncoding.EncodeToString(response)
+	for len(encoded) >= ChunkSize {
+		payloads = append(payloads, encoded[:ChunkSize])
+		encoded = encoded[ChunkSize:]
+	}
+	// A payload shorter than the chunk size ends the response
+	if len(encoded) == 0 {
+		encoded = "+"
+	}
+	return append(payloads, encoded)
+}
+
+// Decoder collects the AUTHENTICATE payloads of a server challenge
+type Decoder struct {
+	buf strings.Builder
+}
+
+// Add a payload to the challenge. The full challenge is returned when the last payload has been added.
+func (dec *Decoder) Add(payload string) (challenge []byte, done bool, err error) {
+	if payload != "+" {
+		dec.buf.WriteString(payload)
+	}
+	if len(payload) == ChunkSize {
+		return nil, false, nil
+	}
+
+	challenge, err = base64.StdEncoding.DecodeString(dec.buf.String())
+	dec.buf.Reset()
+	return challenge, true, err
+}
+
+// plain is the PLAIN mechanism (RFC 4616)
+type plain struct {
+	username, password string
+}
+
+func (mech *plain) Name() string {
+	return Plain
+}
+
+func (mech *plain) Next(challenge []byte) ([]byte, error) {
+	return []byte(mech.username + "\x00" + mech.username + "\x00" + mech.password), nil
+}
+
+// external is the EXTERNAL mechanism (RFC 4422). The identity comes from the TLS client certificate.
+type external struct{}
+
+func (mech *external) Name() string {
+	return External
+}
+
+func (mech *external) Next(challenge []byte) ([]byte, error) {
+	return nil, nil
+}
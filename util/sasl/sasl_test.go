@@ -0,0 +1,140 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sasl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []byte
+		lengths  []int
+	}{
+		{"empty", nil, []int{1}},
+		{"short", []byte("\x00user\x00pass"), []int{16}},
+		{"exactly one chunk", bytes.Repeat([]byte("a"), 300), []int{ChunkSize, 1}},
+		{"more than one chunk", bytes.Repeat([]byte("a"), 301), []int{ChunkSize, 4}},
+	}
+	for _, test := range tests {
+		payloads := Encode(test.response)
+		if len(payloads) != len(test.lengths) {
+			t.Errorf("%s: expected %d payloads, got %d", test.name, len(test.lengths), len(payloads))
+			continue
+		}
+		for i, payload := range payloads {
+			if len(payload) != test.lengths[i] {
+				t.Errorf("%s: expected payload %d to be %d characters long, got %d", test.name, i, test.lengths[i], len(payload))
+			}
+		}
+
+		var dec Decoder
+		for i, payload := range payloads {
+			challenge, done, err := dec.Add(payload)
+			if err != nil {
+				t.Errorf("%s: failed to decode payload %d: %s", test.name, i, err)
+			} else if done != (i == len(payloads)-1) {
+				t.Errorf("%s: expected only the last payload to finish the challenge", test.name)
+			} else if done && !bytes.Equal(challenge, test.response) {
+				t.Errorf("%s: expected %q, got %q", test.name, test.response, challenge)
+			}
+		}
+	}
+}
+
+func TestSupported(t *testing.T) {
+	tests := []struct {
+		name, list string
+		expected   bool
+	}{
+		{"plain", "EXTERNAL,PLAIN", true},
+		{"SCRAM-SHA-256", "PLAIN", false},
+		{"EXTERNAL", "", true},
+	}
+	for _, test := range tests {
+		if output := Supported(test.name, test.list); output != test.expected {
+			t.Errorf("Supported(%q, %q) = %t, expected %t", test.name, test.list, output, test.expected)
+		}
+	}
+}
+
+// The test vector from RFC 7677 section 3
+const (
+	scramNonce       = "rOprNGfwEbeRWgbNEkqO"
+	scramServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	scramClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	scramServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+// newTestScram creates a SCRAM mechanism that has sent the client-first message of the RFC 7677 test vector
+func newTestScram() *scram {
+	return &scram{username: "user", password: "pencil", step: 1, nonce: scramNonce, clientFirstBare: "n=user,r=" + scramNonce}
+}
+
+func TestScram(t *testing.T) {
+	mech := newTestScram()
+	response, err := mech.Next([]byte(scramServerFirst))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(response) != scramClientFinal {
+		t.Errorf("Expected client-final message %q, got %q", scramClientFinal, response)
+	}
+	if _, err = mech.Next([]byte(scramServerFinal)); err != nil {
+		t.Errorf("Expected the server signature to be accepted, got %s", err)
+	}
+}
+
+func TestScramErrors(t *testing.T) {
+	tests := []struct {
+		name                     string
+		serverFirst, serverFinal string
+	}{
+		{"nonce not extended", "r=" + scramNonce + ",s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", ""},
+		{"wrong nonce", "r=abc,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", ""},
+		{"invalid iterations", "r=" + scramNonce + "x,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0", ""},
+		{"wrong signature", scramServerFirst, "v=AAAA"},
+		{"server error", scramServerFirst, "e=invalid-proof"},
+	}
+	for _, test := range tests {
+		mech := newTestScram()
+		_, err := mech.Next([]byte(test.serverFirst))
+		if len(test.serverFinal) > 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error in client-final message: %s", test.name, err)
+				continue
+			}
+			_, err = mech.Next([]byte(test.serverFinal))
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestScramClientFirst(t *testing.T) {
+	mech := &scram{username: "a=b,c"}
+	response, err := mech.Next(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(response), "n,,n=a=3Db=2Cc,r=") {
+		t.Errorf("Expected an escaped username in the client-first message, got %q", response)
+	}
+}
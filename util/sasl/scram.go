@@ -0,0 +1,145 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package sasl contains the SASL mechanisms used to authenticate to IRC networks
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scram is the SCRAM-SHA-256 mechanism (RFC 7677) without channel binding
+type scram struct {
+	username, password string
+
+	step            int
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func (mech *scram) Name() string {
+	return ScramSHA256
+}
+
+func (mech *scram) Next(challenge []byte) ([]byte, error) {
+	mech.step++
+	switch mech.step {
+	case 1:
+		return mech.clientFirst()
+	case 2:
+		return mech.clientFinal(string(challenge))
+	case 3:
+		return nil, mech.verify(string(challenge))
+	default:
+		return nil, errors.New("Unexpected SCRAM challenge")
+	}
+}
+
+func (mech *scram) clientFirst() ([]byte, error) {
+	nonce := make([]byte, 24)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	mech.nonce = base64.RawStdEncoding.EncodeToString(nonce)
+	mech.clientFirstBare = "n=" + scramEscape(mech.username) + ",r=" + mech.nonce
+	return []byte("n,," + mech.clientFirstBare), nil
+}
+
+func (mech *scram) clientFinal(serverFirst string) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce, salt64, iterStr := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, mech.nonce) || len(nonce) == len(mech.nonce) {
+		return nil, errors.New("Invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid salt: %s", err)
+	}
+	iterations, err := strconv.Atoi(iterStr)
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("Invalid iteration count %s", iterStr)
+	}
+
+	clientFinalBare := "c=biws,r=" + nonce
+	authMessage := []byte(mech.clientFirstBare + "," + serverFirst + "," + clientFinalBare)
+
+	saltedPassword := hi([]byte(mech.password), salt, iterations)
+	clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	clientSignature := hmacSHA256(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverKey := hmacSHA256(saltedPassword, []byte("Server Key"))
+	mech.serverSignature = hmacSHA256(serverKey, authMessage)
+	return []byte(clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (mech *scram) verify(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("Server rejected authentication: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, mech.serverSignature) {
+		return errors.New("Invalid server signature")
+	}
+	return nil
+}
+
+// hi is the PBKDF2 function with HMAC-SHA-256 and a single output block
+func hi(password, salt []byte, iterations int) []byte {
+	u := hmacSHA256(password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSHA256(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func scramAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) > 2 && attr[1] == '=' {
+			attrs[attr[:1]] = attr[2:]
+		}
+	}
+	return attrs
+}
+
+func scramEscape(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
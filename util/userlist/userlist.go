@@ -18,139 +18,245 @@
 package userlist
 
 import (
+	"sort"
 	"strings"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/util/casemap"
 )
 
-// List is a wrapper for sorting user lists
-type List []string
-
-func (s List) Len() int {
-	return len(s)
-}
-func (s List) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-// LevelOf gets the int level of the given rune
-func LevelOf(r rune) int {
-	switch r {
-	case '~':
-		return 5
-	case '&':
-		return 4
-	case '@':
-		return 3
-	case '%':
-		return 2
-	case '+':
-		return 1
-	default:
+// Prefixes contains the nick prefixes a server uses from the highest to the lowest, e.g. ~&@%+
+type Prefixes string
+
+// DefaultPrefixes are used if the server hasn't advertised its prefixes
+const DefaultPrefixes Prefixes = "~&@%+"
+
+// LevelOf gets the int level of the given prefix. The lowest prefix has level
+// 1 and non-prefixes have level 0.
+func (p Prefixes) LevelOf(r rune) int {
+	i := strings.IndexRune(string(p), r)
+	if i < 0 {
 		return 0
 	}
+	return len(p) - i
 }
 
-// NameOf gets a basic name of the given level
-func NameOf(level int) string {
-	switch level {
-	case 5:
+// LevelOfByte gets the int level of the given byte
+func (p Prefixes) LevelOfByte(b byte) int {
+	return p.LevelOf(rune(b))
+}
+
+// NameOfMode gets a basic name of the given prefix mode
+func NameOfMode(mode rune) string {
+	switch mode {
+	case 'q':
 		return "owner"
-	case 4:
+	case 'a':
 		return "admin"
-	case 3:
+	case 'o':
 		return "operator"
-	case 2:
+	case 'h':
 		return "half-op"
-	case 1:
+	case 'v':
 		return "voice"
 	default:
 		return ""
 	}
 }
 
-// LevelOfByte gets the int level of the given byte
-func LevelOfByte(b byte) int {
-	return LevelOf(rune(b))
-}
-
-// PrefixOf gets the rune prefix of the given level
-func PrefixOf(level int) rune {
-	switch level {
-	case 5:
-		return '~'
-	case 4:
-		return '&'
-	case 3:
-		return '@'
-	case 2:
-		return '%'
-	case 1:
-		return '+'
-	default:
-		return 0
+// Rules contains the rules of a server for comparing and sorting nicks
+type Rules struct {
+	Prefixes    Prefixes
+	CaseMapping casemap.Mapping
+}
+
+// AddPrefix adds the given prefix to a prefix string, keeping the prefixes
+// ordered from the highest to the lowest.
+func (rules Rules) AddPrefix(prefixes string, prefix rune) string {
+	if rules.Prefixes.LevelOf(prefix) == 0 || strings.ContainsRune(prefixes, prefix) {
+		return prefixes
+	}
+	return rules.sortPrefixes(prefixes + string(prefix))
+}
+
+// RemovePrefix removes the given prefix from a prefix string
+func (rules Rules) RemovePrefix(prefixes string, prefix rune) string {
+	return strings.Replace(prefixes, string(prefix), "", -1)
+}
+
+// sortPrefixes orders the given prefixes from the highest to the lowest
+func (rules Rules) sortPrefixes(prefixes string) string {
+	var sorted []byte
+	for i := 0; i < len(rules.Prefixes); i++ {
+		if strings.IndexByte(prefixes, rules.Prefixes[i]) >= 0 {
+			sorted = append(sorted, rules.Prefixes[i])
+		}
+	}
+	return string(sorted)
+}
+
+// ParseName parses an entry of a NAMES reply. With multi-prefix the entry
+// contains all the prefixes of the user, and with userhost-in-names also the
+// user and host, e.g. @+nick!user@host.
+func ParseName(entry string, rules Rules) *messages.Member {
+	var i int
+	for i < len(entry) && rules.Prefixes.LevelOfByte(entry[i]) > 0 {
+		i++
+	}
+	member := &messages.Member{Prefixes: rules.sortPrefixes(entry[:i])}
+	nick := entry[i:]
+	if at := strings.IndexByte(nick, '@'); at >= 0 {
+		member.Host = nick[at+1:]
+		nick = nick[:at]
+	}
+	if excl := strings.IndexByte(nick, '!'); excl >= 0 {
+		member.User = nick[excl+1:]
+		nick = nick[:excl]
 	}
+	member.Nick = nick
+	return member
+}
+
+// List is the list of members of a channel
+type List []*messages.Member
+
+// sorter sorts user lists by the highest prefix and then alphabetically
+type sorter struct {
+	list  List
+	rules Rules
+}
+
+func (s sorter) Len() int {
+	return len(s.list)
+}
+
+func (s sorter) Swap(i, j int) {
+	s.list[i], s.list[j] = s.list[j], s.list[i]
 }
 
-func (s List) Less(i, j int) bool {
-	levelI := LevelOfByte(s[i][0])
-	levelJ := LevelOfByte(s[j][0])
+func (s sorter) Less(i, j int) bool {
+	levelI := s.rules.level(s.list[i])
+	levelJ := s.rules.level(s.list[j])
 	if levelI > levelJ {
 		return true
 	} else if levelI < levelJ {
 		return false
 	} else {
-		return strings.ToLower(s[i]) < strings.ToLower(s[j])
+		return s.rules.CaseMapping.ToLower(s.list[i].Nick) < s.rules.CaseMapping.ToLower(s.list[j].Nick)
 	}
 }
 
-// Merge the given string list with this user list
-func (s List) Merge(other []string) List {
-Outer:
-	for _, str := range other {
-		if len(str) == 0 {
-			continue
-		}
-		for _, strOld := range s {
-			if str == strOld {
-				continue Outer
-			}
+// level gets the level of the highest prefix of the given member
+func (rules Rules) level(member *messages.Member) int {
+	if len(member.Prefixes) == 0 {
+		return 0
+	}
+	return rules.Prefixes.LevelOfByte(member.Prefixes[0])
+}
+
+// Sort the list by the highest prefix and then alphabetically
+func (s List) Sort(rules Rules) {
+	sort.Sort(sorter{list: s, rules: rules})
+}
+
+// Index gets the index of the given nick in the list, or -1 if the nick isn't in the list
+func (s List) Index(nick string, rules Rules) int {
+	for i, member := range s {
+		if rules.CaseMapping.Equal(nick, member.Nick) {
+			return i
 		}
-		s = append(s, str)
 	}
+	return -1
+}
+
+// Find gets the member with the given nick, or nil if the nick isn't in the list
+func (s List) Find(nick string, rules Rules) *messages.Member {
+	if i := s.Index(nick, rules); i >= 0 {
+		return s[i]
+	}
+	return nil
+}
+
+// Put adds the given member to the list. If the nick is already in the list,
+// the prefixes and the non-empty details of the member are updated instead.
+func (s List) Put(member *messages.Member, rules Rules) List {
+	existing := s.Find(member.Nick, rules)
+	if existing == nil {
+		return append(s, member)
+	}
+	existing.Nick = member.Nick
+	existing.Prefixes = member.Prefixes
+	merge(existing, member)
 	return s
 }
 
-// Contains checks if the given user is in this UserList
-func (s List) Contains(user string) (bool, int) {
-	for i, u := range s {
-		if user == u {
-			return true, i
-		} else if LevelOfByte(u[0]) > 0 && user == u[1:] {
-			return true, i
+// Remove the given nick from the list
+func (s List) Remove(nick string, rules Rules) List {
+	if i := s.Index(nick, rules); i >= 0 {
+		s[i] = s[len(s)-1]
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// Replace returns the given list with the details that it lacks copied from
+// this list. Used when a new NAMES reply replaces the old member list.
+func (s List) Replace(newList List, rules Rules) List {
+	for _, member := range newList {
+		if old := s.Find(member.Nick, rules); old != nil {
+			fill(member, old)
 		}
 	}
-	return false, -1
+	return newList
+}
+
+// merge copies the non-empty details of one member to another
+func merge(to, from *messages.Member) {
+	if len(from.User) > 0 {
+		to.User = from.User
+	}
+	if len(from.Host) > 0 {
+		to.Host = from.Host
+	}
+	if len(from.Account) > 0 {
+		to.Account = from.Account
+	}
+	if len(from.Away) > 0 {
+		to.Away = from.Away
+	}
+	if len(from.RealName) > 0 {
+		to.RealName = from.RealName
+	}
 }
 
-// SetPrefix sets the prefix of the given user
-func (s List) SetPrefix(user string, prefix string) bool {
-	if LevelOfByte(prefix[0]) == 0 {
-		prefix = ""
+// fill copies the details that one member lacks from another
+func fill(to, from *messages.Member) {
+	if len(to.User) == 0 {
+		to.User = from.User
+	}
+	if len(to.Host) == 0 {
+		to.Host = from.Host
+	}
+	if len(to.Account) == 0 {
+		to.Account = from.Account
 	}
-	if LevelOfByte(user[0]) > 0 {
-		user = user[1:]
+	if len(to.Away) == 0 {
+		to.Away = from.Away
 	}
-	for i, u := range s {
-		if LevelOfByte(u[0]) > 0 {
-			if u[1:] == user {
-				s[i] = prefix + user
-				return true
-			}
+	if len(to.RealName) == 0 {
+		to.RealName = from.RealName
+	}
+}
+
+// Nicks gets the nicks in the list with their highest prefixes
+func (s List) Nicks() []string {
+	var nicks = make([]string, len(s))
+	for i, member := range s {
+		if len(member.Prefixes) > 0 {
+			nicks[i] = member.Prefixes[:1] + member.Nick
 		} else {
-			if u == user {
-				s[i] = prefix + user
-				return true
-			}
+			nicks[i] = member.Nick
 		}
 	}
-	return false
+	return nicks
 }
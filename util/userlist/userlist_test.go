@@ -0,0 +1,105 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package userlist
+
+import (
+	"reflect"
+	"testing"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/util/casemap"
+)
+
+var testRules = Rules{Prefixes: DefaultPrefixes, CaseMapping: casemap.RFC1459}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		entry    string
+		expected messages.Member
+	}{
+		{"nick", messages.Member{Nick: "nick"}},
+		{"@nick", messages.Member{Nick: "nick", Prefixes: "@"}},
+		{"+@nick", messages.Member{Nick: "nick", Prefixes: "@+"}},
+		{"~@+nick!user@host.example", messages.Member{Nick: "nick", Prefixes: "~@+", User: "user", Host: "host.example"}},
+		{"nick@host", messages.Member{Nick: "nick", Host: "host"}},
+	}
+	for _, test := range tests {
+		if member := ParseName(test.entry, testRules); !reflect.DeepEqual(*member, test.expected) {
+			t.Errorf("ParseName(%q) = %+v, expected %+v", test.entry, *member, test.expected)
+		}
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	tests := []struct {
+		prefixes string
+		add      rune
+		expected string
+	}{
+		{"", '@', "@"},
+		{"+", '@', "@+"},
+		{"~+", '%', "~%+"},
+		{"@", '@', "@"},
+		{"@", '!', "@"},
+	}
+	for _, test := range tests {
+		if output := testRules.AddPrefix(test.prefixes, test.add); output != test.expected {
+			t.Errorf("AddPrefix(%q, %c) = %q, expected %q", test.prefixes, test.add, output, test.expected)
+		}
+	}
+	if output := testRules.RemovePrefix("~@+", '@'); output != "~+" {
+		t.Errorf("RemovePrefix(\"~@+\", '@') = %q, expected \"~+\"", output)
+	}
+	if level := DefaultPrefixes.LevelOf('~'); level != 5 {
+		t.Errorf("Expected ~ to be level 5, got %d", level)
+	} else if level = DefaultPrefixes.LevelOf('x'); level != 0 {
+		t.Errorf("Expected x to be level 0, got %d", level)
+	}
+}
+
+func TestList(t *testing.T) {
+	var list List
+	for _, entry := range []string{"+zed", "alice!a@a.example", "@Bob", "[cat]"} {
+		list = list.Put(ParseName(entry, testRules), testRules)
+	}
+	list.Sort(testRules)
+	if nicks := list.Nicks(); !reflect.DeepEqual(nicks, []string{"@Bob", "+zed", "alice", "[cat]"}) {
+		t.Errorf("Unexpected sorted list %v", nicks)
+	}
+
+	// The nick is matched with the casemapping and the details are kept
+	list = list.Put(&messages.Member{Nick: "ALICE", Prefixes: "@", Account: "alice"}, testRules)
+	if member := list.Find("{cat}", testRules); member == nil {
+		t.Error("Expected {cat} to match [cat] with rfc1459")
+	}
+	if alice := list.Find("alice", testRules); alice == nil {
+		t.Fatal("Expected alice to be in the list")
+	} else if alice.Nick != "ALICE" || alice.Prefixes != "@" || alice.User != "a" || alice.Account != "alice" {
+		t.Errorf("Unexpected member after Put: %+v", *alice)
+	}
+
+	// A new NAMES reply keeps the details of the old list
+	replaced := list.Replace(List{{Nick: "alice", Prefixes: "+"}, {Nick: "dave"}}, testRules)
+	if len(replaced) != 2 || replaced[0].Host != "a.example" || replaced[0].Prefixes != "+" {
+		t.Errorf("Unexpected list after Replace: %+v", *replaced[0])
+	}
+
+	list = list.Remove("bob", testRules)
+	if list.Index("Bob", testRules) >= 0 || len(list) != 3 {
+		t.Errorf("Expected Bob to be removed, got %v", list.Nicks())
+	}
+}
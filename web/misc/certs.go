@@ -0,0 +1,101 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/clientcert"
+)
+
+// clientCert handles /network/<network>/cert
+//
+// GET returns the details and fingerprints of the certificate, POST generates
+// a new self-signed certificate (the key type can be given with the key query
+// parameter), PUT replaces the certificate with a PEM encoded certificate and
+// private key from the body and DELETE removes the certificate.
+func clientCert(w http.ResponseWriter, r *http.Request, network string, user interfaces.User) {
+	net := user.GetNetwork(network)
+	if net == nil {
+		errors.Write(w, errors.NetworkNotFound)
+		return
+	}
+
+	var info *clientcert.Info
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		info, err = net.GetClientCert()
+		if err == nil && info == nil {
+			errors.Write(w, errors.CertNotFound)
+			return
+		}
+	case http.MethodPost:
+		keyType := r.URL.Query().Get("key")
+		if keyType != "" && keyType != clientcert.RSA && keyType != clientcert.ECDSA {
+			errors.Write(w, errors.FieldFormatting.WithExtraInfo("The key type must be rsa or ecdsa"))
+			return
+		}
+		info, err = net.GenerateClientCert(keyType)
+		if err == nil {
+			log.Debugf("%s generated a client certificate for network %s of %s\n", getIP(r), net.GetName(), user.GetEmail())
+		}
+	case http.MethodPut:
+		var data []byte
+		data, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			errors.Write(w, errors.BodyNotFound)
+			return
+		}
+		info, err = net.SetClientCert(data)
+		if err != nil {
+			errors.Write(w, errors.InvalidCert.WithExtraInfo(err.Error()))
+			return
+		}
+		log.Debugf("%s uploaded a client certificate for network %s of %s\n", getIP(r), net.GetName(), user.GetEmail())
+	case http.MethodDelete:
+		err = net.RemoveClientCert()
+		if err == nil {
+			log.Debugf("%s removed the client certificate of network %s of %s\n", getIP(r), net.GetName(), user.GetEmail())
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	default:
+		w.Header().Add("Allow", http.MethodGet+","+http.MethodPost+","+http.MethodPut+","+http.MethodDelete)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	if err != nil {
+		log.Warnf("Failed to handle client certificate of network %s of %s: %s\n", net.GetName(), user.GetEmail(), err)
+		errors.Write(w, errors.Internal)
+		return
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
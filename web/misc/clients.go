@@ -0,0 +1,49 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/web/auth"
+)
+
+// Clients HTTP handler. Lists the connected clients of the user.
+func Clients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	authd, user := auth.Check(w, r)
+	if !authd {
+		errors.Write(w, errors.NotAuthenticated)
+		return
+	}
+
+	data, err := json.Marshal(user.GetClients())
+	if err != nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
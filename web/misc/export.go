@@ -0,0 +1,276 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
+	"maunium.net/go/mauirc-server/util/irclog"
+	"maunium.net/go/mauirc-server/web/auth"
+)
+
+// exportPageSize is the number of messages fetched from the database at once while exporting
+const exportPageSize = 500
+
+// Export HTTP handler
+func Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	authd, user := auth.Check(w, r)
+	if !authd {
+		errors.Write(w, errors.NotAuthenticated)
+		return
+	}
+
+	params := r.URL.Query()
+	protocol, ok := messages.ParseProtocol(params.Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+
+	var query database.HistoryQuery
+	var err error
+	if query.Since, err = parseTime(params.Get("since"), protocol); err != nil {
+		errors.Write(w, errors.FieldFormatting)
+		return
+	} else if query.Until, err = parseTime(params.Get("until"), protocol); err != nil {
+		errors.Write(w, errors.FieldFormatting)
+		return
+	}
+
+	var loc = time.UTC
+	if tz := params.Get("tz"); len(tz) > 0 {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			errors.Write(w, errors.FieldFormatting)
+			return
+		}
+	}
+
+	args := strings.Split(r.URL.EscapedPath(), "/")[2:]
+	if len(args) > 0 && len(args[len(args)-1]) == 0 {
+		args = args[:len(args)-1]
+	}
+	if len(args) > 0 {
+		query.Network = args[0]
+	}
+	if len(args) > 1 {
+		query.Channel, _ = url.QueryUnescape(args[1])
+	}
+
+	email := user.GetEmail()
+	format := params.Get("format")
+	log.Debugf("%s requested a %s export (network: %s, channel: %s, since: %d, until: %d) of %s\n",
+		getIP(r), format, query.Network, query.Channel, query.Since, query.Until, email)
+	switch format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="mauirc-export.ndjson"`)
+		err = exportJSON(w, email, query, protocol)
+	case "text":
+		var channels []database.HistoryQuery
+		channels, err = exportChannels(email, query)
+		if err != nil {
+			break
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="mauirc-export.zip"`)
+		err = exportText(w, email, channels, loc)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="mauirc-export.html"`)
+		err = exportHTML(w, email, query, loc)
+	default:
+		errors.Write(w, errors.FieldFormatting)
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Failed to export history of %s: %s\n", email, err)
+		// This only reaches the client if the export failed before anything was written
+		errors.Write(w, errors.Internal)
+	}
+}
+
+// forEachMessage calls the given function for every message matching the query, oldest first
+func forEachMessage(email string, query database.HistoryQuery, do func(msg messages.Message) error) error {
+	query.Forward = true
+	query.Limit = exportPageSize
+	for {
+		results, err := database.QueryHistory(email, query)
+		if err != nil {
+			return err
+		}
+		// The results are sorted newest first
+		for i := len(results) - 1; i >= 0; i-- {
+			if err = do(results[i]); err != nil {
+				return err
+			}
+		}
+		if len(results) < query.Limit {
+			return nil
+		}
+		query.After = results[0].ID
+	}
+}
+
+// exportChannels splits the given query into one query per channel
+func exportChannels(email string, query database.HistoryQuery) ([]database.HistoryQuery, error) {
+	if len(query.Network) > 0 && len(query.Channel) > 0 {
+		return []database.HistoryQuery{query}, nil
+	}
+
+	channels, err := database.Channels(email)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []database.HistoryQuery
+	for network, chans := range channels {
+		if len(query.Network) > 0 && network != query.Network {
+			continue
+		}
+		for _, channel := range chans {
+			chQuery := query
+			chQuery.Network = network
+			chQuery.Channel = channel
+			queries = append(queries, chQuery)
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].Network != queries[j].Network {
+			return queries[i].Network < queries[j].Network
+		}
+		return queries[i].Channel < queries[j].Channel
+	})
+	return queries, nil
+}
+
+// exportJSON writes the messages as JSON in the given protocol version, one per line
+func exportJSON(w io.Writer, email string, query database.HistoryQuery, protocol int) error {
+	enc := json.NewEncoder(w)
+	return forEachMessage(email, query, func(msg messages.Message) error {
+		return enc.Encode(msg.ForProtocol(protocol))
+	})
+}
+
+// logFileName gets the name of the log file of the given channel in text exports
+func logFileName(network, channel string) string {
+	if len(channel) == 0 {
+		channel = "server"
+	}
+	replacer := strings.NewReplacer("/", "_", "\\", "_", "..", "_")
+	return replacer.Replace(network) + "/" + replacer.Replace(channel) + ".log"
+}
+
+func exportText(w io.Writer, email string, channels []database.HistoryQuery, loc *time.Location) error {
+	zw := zip.NewWriter(w)
+	for _, query := range channels {
+		file, err := zw.Create(logFileName(query.Network, query.Channel))
+		if err != nil {
+			return err
+		}
+
+		buf := bufio.NewWriter(file)
+		var prev time.Time
+		err = forEachMessage(email, query, func(msg messages.Message) error {
+			t := msg.Time().In(loc)
+			if !irclog.SameDay(prev, t) {
+				fmt.Fprintln(buf, irclog.DayChanged(t))
+			}
+			prev = t
+			_, err := fmt.Fprintln(buf, irclog.Line(msg, loc))
+			return err
+		})
+		if err != nil {
+			return err
+		} else if err = buf.Flush(); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const htmlExportHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mauIRC history export</title>
+<style>
+body { font-family: monospace; background: #fff; color: #222; }
+.day { margin-top: 1em; font-weight: bold; }
+.time, .channel { color: #888; }
+.line.join, .line.part, .line.quit, .line.kick, .line.mode, .line.nick, .line.topic { color: #3a7; }
+.line.action { font-style: italic; }
+.line.own .text { color: #14a; }
+</style>
+</head>
+<body>
+`
+
+const htmlExportFooter = `</body>
+</html>
+`
+
+func exportHTML(w io.Writer, email string, query database.HistoryQuery, loc *time.Location) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString(htmlExportHeader)
+	showChannel := len(query.Channel) == 0
+	var prev time.Time
+	err := forEachMessage(email, query, func(msg messages.Message) error {
+		t := msg.Time().In(loc)
+		if !irclog.SameDay(prev, t) {
+			fmt.Fprintf(buf, "<div class=\"day\">%s</div>\n", html.EscapeString(irclog.DayChanged(t)))
+		}
+		prev = t
+
+		class := html.EscapeString(msg.Command)
+		if msg.OwnMsg {
+			class += " own"
+		}
+		fmt.Fprintf(buf, "<div class=\"line %s\"><span class=\"time\">[%s]</span> ", class, t.Format(irclog.TimeFormat))
+		if showChannel {
+			fmt.Fprintf(buf, "<span class=\"channel\">%s@%s</span> ", html.EscapeString(msg.Channel), html.EscapeString(msg.Network))
+		}
+		_, err := fmt.Fprintf(buf, "<span class=\"text\">%s</span></div>\n", html.EscapeString(irclog.Format(msg)))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	buf.WriteString(htmlExportFooter)
+	return buf.Flush()
+}
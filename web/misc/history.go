@@ -18,11 +18,14 @@
 package misc
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"maunium.net/go/mauirc-server/common/errors"
 	"maunium.net/go/mauirc-server/common/messages"
@@ -31,7 +34,9 @@ import (
 	"maunium.net/go/mauirc-server/web/util"
 )
 
-// History HTTP handler
+// History HTTP handler. The response is a HistoryPage in protocol version 3
+// and newer and a plain list of messages in older versions. The cursor of the
+// next page is also in the X-Next-Cursor header.
 func History(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		w.Header().Add("Allow", "GET")
@@ -45,42 +50,149 @@ func History(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	n, err := strconv.Atoi(r.URL.Query().Get("n"))
-	if err != nil || n <= 0 {
-		n = 256
+	protocol, ok := messages.ParseProtocol(r.URL.Query().Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+	query, err := parseHistoryQuery(r.URL.Query(), protocol)
+	if err != nil {
+		errors.Write(w, errors.FieldFormatting)
+		return
 	}
 
-	args := strings.Split(r.RequestURI, "/")[2:]
+	args := strings.Split(r.URL.EscapedPath(), "/")[2:]
 	if len(args) > 0 && len(args[len(args)-1]) == 0 {
 		args = args[:len(args)-1]
 	}
+	if len(args) > 0 {
+		query.Network = args[0]
+	}
+	if len(args) > 1 {
+		query.Channel, _ = url.QueryUnescape(args[1])
+	}
 
-	results, err := getHistory(user.GetEmail(), getIP(r), n, args)
-
+	log.Debugf("%s requested %d messages of history (network: %s, channel: %s, before: %d, after: %d, since: %d, until: %d) for %s\n",
+		getIP(r), query.Limit, query.Network, query.Channel, query.Before, query.After, query.Since, query.Until, user.GetEmail())
+	results, err := database.QueryHistory(user.GetEmail(), query)
 	if err != nil {
+		log.Errorf("Failed to get history of %s: %s\n", user.GetEmail(), err)
 		errors.Write(w, errors.Internal)
 		return
 	}
 
-	json, err := json.Marshal(results)
+	results = messages.MessagesForProtocol(results, protocol)
+	next := nextCursor(query, results)
+	var payload interface{} = results
+	if protocol >= messages.ProtocolHistoryPage {
+		payload = messages.HistoryPage{Messages: results, NextCursor: next}
+	}
+
+	json, err := json.Marshal(payload)
 	if err != nil {
 		log.Errorln("Error while processing /history request by %s: %s", util.GetIP(r), err)
 		errors.Write(w, errors.Internal)
 		return
 	}
+
+	if len(next) > 0 {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	w.Write(json)
 }
 
-func getHistory(email, ip string, n int, args []string) ([]messages.Message, error) {
-	if len(args) == 0 {
-		log.Debugf("%s requested %d messages of history for %s\n", ip, n, email)
-		return database.GetHistory(email, n)
-	} else if len(args) == 1 {
-		log.Debugf("%s requested %d messages of the history of %s for %s\n", ip, n, args[0], email)
-		return database.GetNetworkHistory(email, args[0], n)
-	} else {
-		channel, _ := url.QueryUnescape(args[1])
-		log.Debugf("%s requested %d messages of the history of %s @ %s for %s\n", ip, n, channel, args[0], email)
-		return database.GetChannelHistory(email, args[0], channel, n)
+const (
+	cursorBefore = "before"
+	cursorAfter  = "after"
+)
+
+// parseHistoryQuery parses the paging parameters of a history request.
+// A cursor takes precedence over the before and after parameters.
+func parseHistoryQuery(params url.Values, protocol int) (query database.HistoryQuery, err error) {
+	n, nErr := strconv.Atoi(params.Get("n"))
+	if nErr != nil || n <= 0 {
+		n = 256
+	}
+	query.Limit = n
+
+	if query.Before, err = parseID(params.Get("before")); err != nil {
+		return
+	} else if query.After, err = parseID(params.Get("after")); err != nil {
+		return
+	} else if query.Since, err = parseTime(params.Get("since"), protocol); err != nil {
+		return
+	} else if query.Until, err = parseTime(params.Get("until"), protocol); err != nil {
+		return
+	}
+
+	if cursor := params.Get("cursor"); len(cursor) > 0 {
+		var direction string
+		var id int64
+		direction, id, err = parseCursor(cursor)
+		if err != nil {
+			return
+		}
+		query.Before, query.After = 0, 0
+		if direction == cursorAfter {
+			query.After = id
+		} else {
+			query.Before = id
+		}
+	}
+	return
+}
+
+func parseID(val string) (int64, error) {
+	if len(val) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// parseTime parses a Unix timestamp or an RFC 3339 date into a Unix timestamp
+// in milliseconds. Unix timestamps are in the unit of the given protocol version.
+func parseTime(val string, protocol int) (int64, error) {
+	if len(val) == 0 {
+		return 0, nil
+	}
+	ts, err := strconv.ParseInt(val, 10, 64)
+	if err == nil {
+		return messages.ClientTimestamp(ts, protocol), nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return 0, err
+	}
+	return messages.UnixMilli(t), nil
+}
+
+// nextCursor creates the cursor for the page after the given results.
+// If the page wasn't full, there are no more messages and the cursor is empty.
+func nextCursor(query database.HistoryQuery, results []messages.Message) string {
+	if len(results) == 0 || len(results) < query.Limit {
+		return ""
+	}
+	if query.Ascending() {
+		return formatCursor(cursorAfter, results[0].ID)
+	}
+	return formatCursor(cursorBefore, results[len(results)-1].ID)
+}
+
+func formatCursor(direction string, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", direction, id)))
+}
+
+func parseCursor(cursor string) (direction string, id int64, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 || (parts[0] != cursorBefore && parts[0] != cursorAfter) {
+		err = fmt.Errorf("Invalid cursor")
+		return
 	}
+	direction = parts[0]
+	id, err = strconv.ParseInt(parts[1], 10, 64)
+	return
 }
@@ -0,0 +1,103 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
+)
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		direction string
+		id        int64
+	}{
+		{cursorBefore, 1},
+		{cursorAfter, 1234567890123},
+	}
+	for _, test := range tests {
+		direction, id, err := parseCursor(formatCursor(test.direction, test.id))
+		if err != nil {
+			t.Errorf("Failed to parse %s cursor: %s", test.direction, err)
+		} else if direction != test.direction || id != test.id {
+			t.Errorf("Expected %s %d, got %s %d", test.direction, test.id, direction, id)
+		}
+	}
+
+	for _, invalid := range []string{"not base64!", formatCursor("sideways", 1), "YmVmb3Jl", base64.RawURLEncoding.EncodeToString([]byte("before:abc"))} {
+		if _, _, err := parseCursor(invalid); err == nil {
+			t.Errorf("Expected an error for the cursor %q", invalid)
+		}
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	page := []messages.Message{{ID: 30}, {ID: 20}, {ID: 10}}
+	tests := []struct {
+		name     string
+		query    database.HistoryQuery
+		results  []messages.Message
+		expected string
+	}{
+		{"backwards", database.HistoryQuery{Limit: 3}, page, formatCursor(cursorBefore, 10)},
+		{"forwards", database.HistoryQuery{Limit: 3, After: 5}, page, formatCursor(cursorAfter, 30)},
+		{"last page", database.HistoryQuery{Limit: 4}, page, ""},
+		{"empty", database.HistoryQuery{Limit: 3}, nil, ""},
+	}
+	for _, test := range tests {
+		if cursor := nextCursor(test.query, test.results); cursor != test.expected {
+			t.Errorf("%s: expected cursor %q, got %q", test.name, test.expected, cursor)
+		}
+	}
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		protocol int
+		expected database.HistoryQuery
+	}{
+		{"defaults", "", messages.ProtocolVersion, database.HistoryQuery{Limit: 256}},
+		{"invalid limit", "n=-5", messages.ProtocolVersion, database.HistoryQuery{Limit: 256}},
+		{"ids", "n=10&before=50&after=20", messages.ProtocolVersion, database.HistoryQuery{Limit: 10, Before: 50, After: 20}},
+		{"cursor overrides ids", "before=50&cursor=" + formatCursor(cursorAfter, 7), messages.ProtocolVersion, database.HistoryQuery{Limit: 256, After: 7}},
+		{"milliseconds", "since=1500000000000&until=1500000001000", messages.ProtocolMilliseconds, database.HistoryQuery{Limit: 256, Since: 1500000000000, Until: 1500000001000}},
+		{"seconds", "since=1500000000", messages.ProtocolMilliseconds - 1, database.HistoryQuery{Limit: 256, Since: 1500000000000}},
+		{"rfc3339", "until=2017-07-14T02:40:00Z", messages.ProtocolVersion, database.HistoryQuery{Limit: 256, Until: 1500000000000}},
+	}
+	for _, test := range tests {
+		params, _ := url.ParseQuery(test.params)
+		query, err := parseHistoryQuery(params, test.protocol)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if query != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, query)
+		}
+	}
+
+	for _, invalid := range []string{"before=abc", "since=yesterday", "cursor=abc"} {
+		params, _ := url.ParseQuery(invalid)
+		if _, err := parseHistoryQuery(params, messages.ProtocolVersion); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
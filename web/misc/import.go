@@ -0,0 +1,195 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/util/logimport"
+	"maunium.net/go/mauirc-server/web/auth"
+)
+
+// MaxImportSize is the maximum size of a log import request in bytes
+const MaxImportSize = 512 << 20
+
+// importResponse is a line in the streamed response of an import request
+type importResponse struct {
+	logimport.Progress
+	Done  bool   `json:"done,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Import HTTP handler. The logs are uploaded either as the request body or as
+// the files of a multipart form of at most MaxImportSize bytes. The progress
+// is streamed as JSON lines.
+func Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", http.MethodPost)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	authd, user := auth.Check(w, r)
+	if !authd {
+		errors.Write(w, errors.NotAuthenticated)
+		return
+	}
+
+	args := strings.Split(r.URL.EscapedPath(), "/")[2:]
+	if len(args) < 2 || len(args[0]) == 0 || len(args[1]) == 0 {
+		errors.Write(w, errors.MissingFields)
+		return
+	}
+	net := user.GetNetwork(args[0])
+	if net == nil {
+		errors.Write(w, errors.NetworkNotFound)
+		return
+	}
+	channel, _ := url.QueryUnescape(args[1])
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	opts := logimport.Options{
+		Network:  net.GetName(),
+		Channel:  channel,
+		Nick:     params.Get("nick"),
+		FileName: params.Get("filename"),
+		Location: time.Local,
+	}
+	if len(opts.Nick) == 0 {
+		opts.Nick = net.GetNetData().Nick
+	}
+	if tz := params.Get("tz"); len(tz) > 0 {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			errors.Write(w, errors.FieldFormatting)
+			return
+		}
+		opts.Location = loc
+	}
+	if date := params.Get("date"); len(date) > 0 {
+		var err error
+		opts.Date, err = time.ParseInLocation("2006-01-02", date, opts.Location)
+		if err != nil {
+			errors.Write(w, errors.FieldFormatting)
+			return
+		}
+	}
+
+	// The response can't be streamed while the request is being read, so the upload is stored first
+	upload, err := spoolUpload(w, r)
+	if err != nil {
+		return
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+	r.Body = upload
+
+	importer, err := logimport.NewImporter(user.GetEmail())
+	if err != nil {
+		log.Errorf("Failed to prepare log import for %s: %s\n", user.GetEmail(), err)
+		errors.Write(w, errors.Internal)
+		return
+	}
+
+	log.Debugf("%s started importing %s logs into %s @ %s for %s\n", getIP(r), format, channel, net.GetName(), user.GetEmail())
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	importer.Progress = func(p logimport.Progress) {
+		enc.Encode(importResponse{Progress: p})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	err = importFiles(r, importer, format, opts)
+	resp := importResponse{Progress: importer.Status(), Done: true}
+	if err != nil {
+		resp.Error = err.Error()
+		log.Debugf("Log import of %s failed: %s\n", user.GetEmail(), err)
+	} else {
+		log.Debugf("%s imported %d messages into %s @ %s for %s\n", getIP(r), resp.Imported, channel, net.GetName(), user.GetEmail())
+	}
+	enc.Encode(resp)
+}
+
+// spoolUpload copies the request body into a temporary file. If it fails, the
+// error is written to the response.
+func spoolUpload(w http.ResponseWriter, r *http.Request) (*os.File, error) {
+	file, err := ioutil.TempFile("", "mauirc-import-")
+	if err != nil {
+		log.Errorln("Failed to create temporary file for log import:", err)
+		errors.Write(w, errors.Internal)
+		return nil, err
+	}
+
+	n, err := io.Copy(file, http.MaxBytesReader(w, r.Body, MaxImportSize))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		if n >= MaxImportSize {
+			errors.Write(w, errors.RequestTooLarge)
+		} else {
+			errors.Write(w, errors.BodyNotFound)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func importFiles(r *http.Request, importer *logimport.Importer, format string, opts logimport.Options) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return importer.Import(format, r.Body, opts)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		} else if len(part.FileName()) == 0 {
+			continue
+		}
+
+		fileOpts := opts
+		fileOpts.FileName = part.FileName()
+		err = importer.Import(format, part, fileOpts)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -18,8 +18,8 @@
 package misc
 
 import (
+	"net"
 	"net/http"
-	"strings"
 
 	"maunium.net/go/mauirc-server/interfaces"
 	"maunium.net/go/maulogger"
@@ -37,5 +37,9 @@ func getIP(r *http.Request) string {
 	if config.TrustHeaders() {
 		return r.Header.Get("X-Forwarded-For")
 	}
-	return strings.Split(r.RemoteAddr, ":")[0]
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
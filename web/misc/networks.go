@@ -18,14 +18,21 @@
 package misc
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
 
 	"maunium.net/go/mauirc-server/common/errors"
 	"maunium.net/go/mauirc-server/common/messages"
 	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/proxy"
+	"maunium.net/go/mauirc-server/util/sasl"
 	"maunium.net/go/mauirc-server/web/auth"
 )
 
@@ -38,6 +45,11 @@ func Network(w http.ResponseWriter, r *http.Request) {
 	}
 
 	args := strings.Split(r.RequestURI, "/")[2:]
+	if path := strings.Split(r.URL.Path, "/")[2:]; len(path) > 1 && path[1] == "cert" {
+		clientCert(w, r, path[0], user)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodDelete:
 		deleteNetwork(w, r, args, user)
@@ -95,7 +107,7 @@ func addNetwork(w http.ResponseWriter, r *http.Request, args []string, user inte
 	}
 
 	user.InitNetworks()
-	user.SendNetworkData(net)
+	user.SendNetworkData(net, nil)
 	log.Debugf("%s created network %s for %s\n", getIP(r), net.GetName(), user.GetEmail())
 }
 
@@ -109,6 +121,16 @@ type editRequest struct {
 	IP              string `json:"ip"`
 	Port            uint16 `json:"port"`
 	ForceDisconnect bool   `json:"forcedisconnect"`
+	// SASL replaces the SASL settings. An empty mechanism disables SASL.
+	SASL *interfaces.SASL `json:"sasl"`
+	// TLS replaces the certificate verification settings
+	TLS *interfaces.TLS `json:"tls"`
+	// Servers replaces the alternate servers
+	Servers *[]interfaces.Server `json:"servers"`
+	// Reconnect replaces the reconnection policy
+	Reconnect *interfaces.Reconnect `json:"reconnect"`
+	// Proxy replaces the proxy settings. An empty type disables the proxy.
+	Proxy *interfaces.Proxy `json:"proxy"`
 }
 
 type editResponse struct {
@@ -132,8 +154,43 @@ func editNetwork(w http.ResponseWriter, r *http.Request, args []string, user int
 		return
 	}
 
+	if data.SASL != nil && len(data.SASL.Mechanism) > 0 {
+		_, err = sasl.New(data.SASL.Mechanism, data.SASL.Username, data.SASL.Password)
+		if err != nil {
+			errors.Write(w, errors.InvalidBodyFormat.WithExtraInfo(err.Error()))
+			return
+		}
+	}
+
+	if data.TLS != nil {
+		err = validateTLS(data.TLS)
+		if err != nil {
+			errors.Write(w, errors.FieldFormatting.WithExtraInfo(err.Error()))
+			return
+		}
+	}
+
+	if data.Proxy != nil && len(data.Proxy.Type) > 0 {
+		err = validateProxy(data.Proxy)
+		if err != nil {
+			errors.Write(w, errors.FieldFormatting.WithExtraInfo(err.Error()))
+			return
+		}
+	}
+
 	var oldData = net.GetNetData()
 	nameUpdates(net, data, oldData)
+	saslUpdate(net, data)
+	if data.TLS != nil {
+		net.SetTLS(data.TLS)
+	}
+	if data.Servers != nil {
+		net.SetServers(*data.Servers)
+	}
+	if data.Reconnect != nil {
+		net.SetReconnect(data.Reconnect)
+	}
+	proxyUpdate(net, data)
 	addrUpdates(net, data, oldData)
 	connectedUpdate(net, data, oldData)
 
@@ -181,6 +238,55 @@ func nameUpdates(net interfaces.Network, data editRequest, oldData messages.NetD
 	}
 }
 
+func saslUpdate(net interfaces.Network, data editRequest) {
+	if data.SASL == nil {
+		return
+	} else if len(data.SASL.Mechanism) == 0 {
+		net.SetSASL(nil)
+	} else {
+		net.SetSASL(data.SASL)
+	}
+}
+
+// validateTLS checks the custom CA and normalizes the pinned fingerprints
+func validateTLS(settings *interfaces.TLS) error {
+	if len(settings.CA) > 0 && !x509.NewCertPool().AppendCertsFromPEM([]byte(settings.CA)) {
+		return fmt.Errorf("The CA doesn't contain any PEM encoded certificates")
+	}
+	for i, fingerprint := range settings.Fingerprints {
+		fingerprint = interfaces.NormalizeFingerprint(fingerprint)
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("%s is not a SHA-256 fingerprint", settings.Fingerprints[i])
+		}
+		settings.Fingerprints[i] = fingerprint
+	}
+	return nil
+}
+
+func proxyUpdate(net interfaces.Network, data editRequest) {
+	if data.Proxy == nil {
+		return
+	} else if len(data.Proxy.Type) == 0 {
+		net.SetProxy(nil)
+	} else {
+		net.SetProxy(data.Proxy)
+	}
+}
+
+// validateProxy checks the proxy type and normalizes it to lowercase
+func validateProxy(settings *interfaces.Proxy) error {
+	settings.Type = strings.ToLower(settings.Type)
+	_, err := proxy.New(proxy.Config{Type: settings.Type, Address: settings.Address}, nil)
+	if err != nil {
+		return err
+	} else if len(settings.Address) > 0 {
+		if _, _, err = net.SplitHostPort(settings.Address); err != nil {
+			return fmt.Errorf("The proxy address must be in the host:port format")
+		}
+	}
+	return nil
+}
+
 func addrUpdates(net interfaces.Network, data editRequest, oldData messages.NetData) {
 	if len(data.IP) > 0 && data.IP != oldData.IP {
 		net.SetIP(data.IP)
@@ -0,0 +1,124 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/interfaces"
+)
+
+type retentionResponse struct {
+	Server   *interfaces.Retention       `json:"server,omitempty"`
+	User     *interfaces.Retention       `json:"user,omitempty"`
+	Networks map[string]networkRetention `json:"networks"`
+}
+
+type networkRetention struct {
+	Network  *interfaces.Retention            `json:"network,omitempty"`
+	Channels map[string]*interfaces.Retention `json:"channels,omitempty"`
+}
+
+// retentionSettings handles /settings/retention[/<network>[/<channel>]]
+func retentionSettings(w http.ResponseWriter, r *http.Request, user interfaces.User) {
+	args := strings.Split(r.URL.EscapedPath(), "/")[3:]
+	if len(args) > 0 && len(args[len(args)-1]) == 0 {
+		args = args[:len(args)-1]
+	}
+
+	var net interfaces.Network
+	var network, channel string
+	if len(args) > 0 {
+		network = args[0]
+		net = user.GetNetwork(args[0])
+		if net == nil {
+			errors.Write(w, errors.NetworkNotFound)
+			return
+		}
+	}
+	if len(args) > 1 {
+		channel, _ = url.QueryUnescape(args[1])
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getRetention(w, user)
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			errors.Write(w, errors.BodyNotFound)
+			return
+		}
+		var policy = &interfaces.Retention{}
+		err = json.Unmarshal(data, policy)
+		if err != nil {
+			errors.Write(w, errors.RequestNotJSON)
+			return
+		} else if policy.MaxAge < 0 || policy.MaxMessages < 0 {
+			errors.Write(w, errors.FieldFormatting)
+			return
+		}
+		setRetention(user, net, channel, policy)
+		log.Debugf("%s set retention policy %+v (network: %s, channel: %s) for %s\n", getIP(r), *policy, network, channel, user.GetEmail())
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		setRetention(user, net, channel, nil)
+		log.Debugf("%s removed retention policy (network: %s, channel: %s) of %s\n", getIP(r), network, channel, user.GetEmail())
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Add("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, ","))
+		errors.Write(w, errors.InvalidMethod)
+	}
+}
+
+func setRetention(user interfaces.User, net interfaces.Network, channel string, policy *interfaces.Retention) {
+	if net == nil {
+		user.SetRetention(policy)
+	} else if len(channel) == 0 {
+		net.SetRetention(policy)
+	} else {
+		net.SetChannelRetention(channel, policy)
+	}
+}
+
+func getRetention(w http.ResponseWriter, user interfaces.User) {
+	resp := retentionResponse{
+		Server:   config.GetRetention(),
+		User:     user.GetRetention(),
+		Networks: make(map[string]networkRetention),
+	}
+	user.GetNetworks().ForEach(func(net interfaces.Network) {
+		resp.Networks[net.GetName()] = networkRetention{
+			Network:  net.GetRetention(),
+			Channels: net.GetChannelRetentions(),
+		}
+	})
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
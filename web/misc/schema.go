@@ -0,0 +1,107 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/util/jsonschema"
+)
+
+var schemaOnce sync.Once
+var schemaData []byte
+
+// Schema HTTP handler. Returns the JSON Schema of the websocket protocol.
+func Schema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	schemaOnce.Do(func() {
+		var err error
+		schemaData, err = json.MarshalIndent(protocolSchema(), "", "  ")
+		if err != nil {
+			log.Errorln("Failed to generate protocol schema:", err)
+		}
+	})
+	if schemaData == nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(schemaData)
+}
+
+// protocolSchema generates the schema of the websocket protocol. Every message
+// type has a container definition for each direction it's sent in, and the
+// inbound and outbound properties list the containers clients and the server send.
+func protocolSchema() jsonschema.Schema {
+	gen := jsonschema.NewGenerator()
+
+	var types []string
+	for typ := range messages.Types {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	var inbound, outbound []interface{}
+	for _, typ := range types {
+		info := messages.Types[typ]
+		if info.Inbound != nil {
+			inbound = append(inbound, containerSchema(gen, "inbound."+typ, typ, info.Inbound, false))
+		}
+		if info.Outbound != nil {
+			outbound = append(outbound, containerSchema(gen, "outbound."+typ, typ, info.Outbound, true))
+		}
+	}
+
+	return jsonschema.Schema{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "mauIRC websocket protocol",
+		"version":     messages.ProtocolVersion,
+		"definitions": gen.Definitions,
+		"inbound":     jsonschema.Schema{"oneOf": inbound},
+		"outbound":    jsonschema.Schema{"oneOf": outbound},
+	}
+}
+
+func containerSchema(gen *jsonschema.Generator, name, typ string, object interface{}, outbound bool) jsonschema.Schema {
+	properties := map[string]interface{}{
+		"type":   jsonschema.Schema{"const": typ},
+		"object": gen.Of(object),
+		"id":     jsonschema.Schema{"type": "string"},
+	}
+	if outbound {
+		properties["seq"] = jsonschema.Schema{"type": "integer", "minimum": 0}
+	}
+	gen.Definitions[name] = jsonschema.Schema{
+		"type":       "object",
+		"properties": properties,
+		"required":   []string{"type", "object"},
+	}
+	return jsonschema.Ref(name)
+}
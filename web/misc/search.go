@@ -0,0 +1,96 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package misc contains HTTP-only misc handlers
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
+	"maunium.net/go/mauirc-server/web/auth"
+)
+
+// Search HTTP handler
+func Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	authd, user := auth.Check(w, r)
+	if !authd {
+		errors.Write(w, errors.NotAuthenticated)
+		return
+	}
+
+	params := r.URL.Query()
+	query := messages.Search{
+		Query:   params.Get("q"),
+		Network: params.Get("network"),
+		Channel: params.Get("channel"),
+		Sender:  params.Get("sender"),
+	}
+	if len(query.Query) == 0 {
+		errors.Write(w, errors.MissingFields)
+		return
+	}
+
+	protocol, ok := messages.ParseProtocol(params.Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+	var err error
+	if query.Since, err = parseTime(params.Get("since"), protocol); err != nil {
+		errors.Write(w, errors.FieldFormatting)
+		return
+	} else if query.Until, err = parseTime(params.Get("until"), protocol); err != nil {
+		errors.Write(w, errors.FieldFormatting)
+		return
+	}
+	query.Limit, _ = strconv.Atoi(params.Get("n"))
+	if context := params.Get("context"); len(context) > 0 {
+		query.Context, err = strconv.Atoi(context)
+		if err != nil {
+			errors.Write(w, errors.FieldFormatting)
+			return
+		} else if query.Context == 0 {
+			// Zero means the default in the database package, negative means none.
+			query.Context = -1
+		}
+	}
+
+	log.Debugf("%s searched for \"%s\" in the history of %s\n", getIP(r), query.Query, user.GetEmail())
+	results, err := database.Search(user.GetEmail(), query)
+	if err != nil {
+		log.Errorf("Failed to search history of %s: %s\n", user.GetEmail(), err)
+		errors.Write(w, errors.Internal)
+		return
+	}
+
+	data, err := json.Marshal(messages.SearchResults{Query: query, Results: results}.ForProtocol(protocol))
+	if err != nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+	w.Write(data)
+}
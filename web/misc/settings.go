@@ -36,7 +36,9 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method == http.MethodGet {
+	if strings.HasPrefix(r.URL.Path, "/settings/retention") {
+		retentionSettings(w, r, user)
+	} else if r.Method == http.MethodGet {
 		getSettings(w, r, user)
 	} else if r.Method == http.MethodPut {
 		putSettings(w, r, user)
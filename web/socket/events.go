@@ -0,0 +1,184 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package socket contains the WebSocket handlers
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/web/auth"
+	"maunium.net/go/mauirc-server/web/util"
+)
+
+// Events serves the message stream as Server-Sent Events for clients that
+// can't use WebSockets. Commands are sent to the Command handler instead.
+//
+// The ID of each event is the epoch and sequence number of the message
+// separated by a colon, so browsers resume automatically with the
+// Last-Event-ID header when they reconnect. The protocol parameter selects
+// the protocol version of the messages.
+func Events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	success, user := auth.Check(w, r)
+	if !success {
+		errors.Write(w, errors.NotAuthenticated)
+		log.Debugln(util.GetIP(r), "tried to connect to event stream without authentication")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errors.Write(w, errors.Internal)
+		return
+	}
+
+	params := r.URL.Query()
+	protocol, ok := messages.ParseProtocol(params.Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+	if lastEventID := r.Header.Get("Last-Event-ID"); len(lastEventID) > 0 {
+		parts := strings.SplitN(lastEventID, ":", 2)
+		if len(parts) == 2 {
+			params.Set("epoch", parts[0])
+			params.Set("seq", parts[1])
+		}
+	}
+
+	s := newSession(user, clientName(r, "sse"), params, protocol)
+	defer s.sub.Close()
+	log.Debugf("%s connected to event stream as %s (connection %d, resume source: %s)\n", util.GetIP(r), user.GetEmail(), s.sub.ID, s.resume.Source)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, msg := range append(append([]messages.Container{hello()}, s.start()...), s.networkData()...) {
+		if writeEvent(w, s.resume.Epoch, s.encode(msg)) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case new, ok := <-s.sub.C():
+			if !ok {
+				log.Debugf("Closing event stream %d of %s: subscription closed\n", s.sub.ID, user.GetEmail())
+				return
+			} else if s.alreadyReplayed(new) {
+				continue
+			}
+			err = writeEvent(w, s.resume.Epoch, s.encode(new))
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			log.Debugf("Event stream %d of %s disconnected\n", s.sub.ID, user.GetEmail())
+			return
+		}
+		if err != nil {
+			log.Debugln("Disconnected:", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes the given message as a Server-Sent Event
+func writeEvent(w http.ResponseWriter, epoch int64, msg messages.Container) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Warnln("Failed to marshal event:", err)
+		return nil
+	}
+
+	if msg.Seq > 0 {
+		_, err = fmt.Fprintf(w, "id: %d:%d\n", epoch, msg.Seq)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+// Command HTTP handler. Handles a single command for clients that receive
+// the message stream with Events or Poll. The body is a message container
+// like the ones sent over the socket and the response is a list of the
+// messages sent in reply. Commands without an ID get no reply. The replies
+// are in the protocol version given in the protocol parameter.
+func Command(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", http.MethodPost)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	success, user := auth.Check(w, r)
+	if !success {
+		errors.Write(w, errors.NotAuthenticated)
+		return
+	}
+
+	protocol, ok := messages.ParseProtocol(r.URL.Query().Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+
+	var data messages.Container
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		errors.Write(w, errors.RequestNotJSON)
+		return
+	}
+
+	replies := []messages.Container{}
+	reply := func(msg messages.Container) {
+		replies = append(replies, msg.ForProtocol(protocol))
+	}
+	if data.Type == messages.MsgHello {
+		// There's no connection to remember the version for, so just check it
+		(&session{user: user}).hello(data, reply)
+	} else {
+		user.HandleCommand(data, protocol, reply)
+	}
+
+	payload, err := json.Marshal(replies)
+	if err != nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(payload)
+}
@@ -0,0 +1,52 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package socket contains the WebSocket handlers
+package socket
+
+import (
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/common/messages"
+)
+
+// hello handles the protocol version the client sent. If the version isn't
+// supported, the client gets an error and false is returned.
+func (s *session) hello(data messages.Container, reply func(messages.Container)) bool {
+	var hello messages.Hello
+	err := data.Decode(&hello)
+	if err != nil {
+		reply(messages.Container{Type: messages.MsgError, ID: data.ID, Object: messages.ErrorResponse{
+			Command:  data.Type,
+			WebError: errors.InvalidBodyFormat.WithExtraInfo(err.Error()),
+		}})
+		return true
+	}
+
+	if !messages.SupportedProtocol(hello.Protocol) {
+		reply(messages.Container{Type: messages.MsgError, ID: data.ID, Object: messages.ErrorResponse{
+			Command:  data.Type,
+			WebError: errors.UnsupportedVersion,
+		}})
+		return false
+	}
+
+	s.setProtocol(hello.Protocol)
+	reply(messages.Container{Type: messages.MsgResponse, ID: data.ID, Object: messages.Response{
+		Command: data.Type,
+		Result:  messages.Hello{Protocol: hello.Protocol, MinProtocol: messages.MinProtocolVersion},
+	}})
+	return true
+}
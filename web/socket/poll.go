@@ -0,0 +1,136 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package socket contains the WebSocket handlers
+package socket
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"maunium.net/go/mauirc-server/common/errors"
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/web/auth"
+)
+
+const (
+	// pollTimeout is how long a poll request waits for new messages
+	pollTimeout = 25 * time.Second
+	// pollLinger is how long a poll request waits for more messages after receiving one
+	pollLinger = 50 * time.Millisecond
+	// maxPollMessages is the maximum number of live messages returned by a single poll request
+	maxPollMessages = 500
+)
+
+// Poll HTTP handler. Serves the message stream with long-polling for clients
+// that can't use WebSockets or Server-Sent Events.
+//
+// The first request shouldn't have any parameters other than protocol. It returns the hello, the
+// state of all networks and the resume info containing the epoch of the
+// stream. The following requests must give the epoch and the highest sequence
+// number received so far in the epoch and seq parameters, and they return the
+// messages after that as soon as there are any. The resume info is always the
+// last message in the response. The protocol parameter selects the protocol
+// version of the messages.
+func Poll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		errors.Write(w, errors.InvalidMethod)
+		return
+	}
+
+	success, user := auth.Check(w, r)
+	if !success {
+		errors.Write(w, errors.NotAuthenticated)
+		return
+	}
+
+	protocol, ok := messages.ParseProtocol(r.URL.Query().Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+
+	s := newSession(user, clientName(r, "long-poll"), r.URL.Query(), protocol)
+	defer s.sub.Close()
+
+	var msgs []messages.Container
+	if !s.resuming {
+		msgs = append(msgs, hello())
+	}
+	start := s.start()
+	// The resume info is recreated after the live messages have been collected
+	msgs = append(msgs, start[:len(start)-1]...)
+	if !s.resuming {
+		msgs = append(msgs, s.networkData()...)
+	}
+
+	msgs = append(msgs, s.wait(r, len(msgs) == 0)...)
+	// The client continues from the last message in this response
+	resume := s.resume
+	for _, msg := range msgs {
+		if msg.Seq > resume.Seq {
+			resume.Seq = msg.Seq
+		}
+	}
+	msgs = append(msgs, messages.Container{Type: messages.MsgResume, Object: resume})
+
+	for i, msg := range msgs {
+		msgs[i] = s.encode(msg)
+	}
+	payload, err := json.Marshal(msgs)
+	if err != nil {
+		errors.Write(w, errors.Internal)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(payload)
+}
+
+// wait collects the live messages of the session. If block is true, it waits
+// for the first message until the poll times out or the client disconnects.
+// After that it returns when no new messages arrive within pollLinger.
+func (s *session) wait(r *http.Request, block bool) (msgs []messages.Container) {
+	timeout := pollLinger
+	if block {
+		timeout = pollTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for len(msgs) < maxPollMessages {
+		select {
+		case new, ok := <-s.sub.C():
+			if !ok {
+				return
+			} else if s.alreadyReplayed(new) {
+				continue
+			}
+			msgs = append(msgs, new)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(pollLinger)
+		case <-timer.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+	return
+}
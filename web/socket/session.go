@@ -0,0 +1,165 @@
+// mauIRC-server - The IRC bouncer/backend system for mauIRC clients.
+// Copyright (C) 2016 Tulir Asokan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package socket contains the WebSocket handlers
+package socket
+
+import (
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"maunium.net/go/mauirc-server/common/messages"
+	"maunium.net/go/mauirc-server/database"
+	"maunium.net/go/mauirc-server/interfaces"
+	"maunium.net/go/mauirc-server/util/hub"
+)
+
+// maxDatabaseReplay is the maximum number of chat messages replayed from the database
+const maxDatabaseReplay = 1000
+
+// session is the subscription of a single client to the message stream of an
+// user. It's shared by all the transports.
+type session struct {
+	user interfaces.User
+	sub  *hub.Subscription
+
+	// The messages to send before the live stream and the ID of the last chat
+	// message that has been replayed from the database.
+	resume     messages.Resume
+	replay     []messages.Container
+	replayFrom int64
+	replayedTo int64
+	// resuming is true if the client gave the position it's resuming from
+	resuming bool
+	// protocol is the protocol version the messages are sent in. It's
+	// accessed atomically, as the client may change it with a hello.
+	protocol int32
+}
+
+// newSession subscribes to the message stream of the user. If the client gave
+// the epoch and sequence number of the last message it received, the messages
+// it missed are replayed from memory. If they're no longer in memory, but the
+// client gave the ID of the last chat message it received, the chat messages
+// are replayed from the database. The messages are sent in the given protocol version.
+func newSession(user interfaces.User, name string, params url.Values, protocol int) *session {
+	epoch, _ := strconv.ParseInt(params.Get("epoch"), 10, 64)
+	lastSeq, seqErr := strconv.ParseUint(params.Get("seq"), 10, 64)
+	lastID, _ := strconv.ParseInt(params.Get("lastid"), 10, 64)
+
+	s := &session{user: user, resuming: seqErr == nil || lastID > 0, protocol: int32(protocol)}
+	sub, replay, seq, ok := user.GetHub().Resume(name, epoch, lastSeq)
+	s.sub = sub
+	s.resume = messages.Resume{Epoch: user.GetHub().Epoch(), Seq: seq, Source: messages.ResumeNone}
+	if seqErr == nil && ok {
+		s.replay = replay
+		s.resume.Source = messages.ResumeMemory
+		s.resume.Replayed = len(replay)
+		s.resume.Complete = true
+	} else if lastID > 0 {
+		s.replayFrom = lastID
+		s.resume.Source = messages.ResumeDatabase
+	} else {
+		// Nothing to resume is complete only if the client didn't try to resume
+		s.resume.Complete = !s.resuming
+	}
+	return s
+}
+
+// setProtocol changes the protocol version the following messages are sent in
+func (s *session) setProtocol(version int) {
+	atomic.StoreInt32(&s.protocol, int32(version))
+}
+
+// getProtocol gets the protocol version of the client
+func (s *session) getProtocol() int {
+	return int(atomic.LoadInt32(&s.protocol))
+}
+
+// encode converts the given message into the protocol version of the client
+func (s *session) encode(msg messages.Container) messages.Container {
+	return msg.ForProtocol(s.getProtocol())
+}
+
+// hello creates the hello message the server sends when a client connects
+func hello() messages.Container {
+	return messages.Container{Type: messages.MsgHello, Object: messages.Hello{
+		Protocol:    messages.ProtocolVersion,
+		MinProtocol: messages.MinProtocolVersion,
+	}}
+}
+
+// start returns the replayed messages followed by the resume info. They must
+// be sent to the client before any messages from the subscription.
+func (s *session) start() []messages.Container {
+	if s.replayFrom > 0 {
+		err := s.loadDatabaseReplay()
+		if err != nil {
+			log.Warnf("Failed to load replay of %s from database: %s\n", s.user.GetEmail(), err)
+		}
+	}
+
+	msgs := append(s.replay, messages.Container{Type: messages.MsgResume, Object: s.resume})
+	s.replay = nil
+	return msgs
+}
+
+// networkData gets the state of all networks of the user. It's sent to this
+// session only and doesn't go through the subscription, so it can't overflow
+// the buffer.
+func (s *session) networkData() (msgs []messages.Container) {
+	s.user.GetNetworks().ForEach(func(net interfaces.Network) {
+		s.user.SendNetworkData(net, func(msg messages.Container) {
+			msgs = append(msgs, msg)
+		})
+	})
+	return
+}
+
+// loadDatabaseReplay loads the chat messages after the last one the client received from the database
+func (s *session) loadDatabaseReplay() error {
+	results, err := database.QueryHistory(s.user.GetEmail(), database.HistoryQuery{
+		After:   s.replayFrom,
+		Forward: true,
+		Limit:   maxDatabaseReplay + 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The results are sorted newest first
+	s.resume.Complete = len(results) <= maxDatabaseReplay
+	if !s.resume.Complete {
+		results = results[1:]
+	}
+	for i := len(results) - 1; i >= 0; i-- {
+		s.replay = append(s.replay, messages.Container{Type: messages.MsgMessage, Object: results[i]})
+	}
+	if len(results) > 0 {
+		s.replayedTo = results[0].ID
+	}
+	s.resume.Replayed = len(results)
+	return nil
+}
+
+// alreadyReplayed checks if the given live message was already replayed from the database
+func (s *session) alreadyReplayed(container messages.Container) bool {
+	if s.replayedTo == 0 || container.Type != messages.MsgMessage {
+		return false
+	}
+	msg, ok := container.Object.(messages.Message)
+	return ok && msg.ID > 0 && msg.ID <= s.replayedTo
+}
@@ -18,7 +18,6 @@
 package socket
 
 import (
-	"bytes"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -26,7 +25,6 @@ import (
 	"github.com/gorilla/websocket"
 	"maunium.net/go/mauirc-server/common/errors"
 	"maunium.net/go/mauirc-server/common/messages"
-	"maunium.net/go/mauirc-server/interfaces"
 	"maunium.net/go/mauirc-server/web/auth"
 	"maunium.net/go/mauirc-server/web/util"
 	"maunium.net/go/maulogger"
@@ -47,12 +45,13 @@ var upgrader = websocket.Upgrader{
 }
 
 type connection struct {
-	ws   *websocket.Conn
-	user interfaces.User
+	*session
+	ws *websocket.Conn
 }
 
 func (c *connection) readPump() {
 	defer func() {
+		c.sub.Close()
 		c.ws.Close()
 	}()
 	for {
@@ -65,13 +64,19 @@ func (c *connection) readPump() {
 		}
 
 		var data messages.Container
-		dec := json.NewDecoder(bytes.NewReader(message))
-		dec.UseNumber()
-		err = dec.Decode(&data)
+		err = json.Unmarshal(message, &data)
 		if err != nil {
+			c.sub.Send(messages.Container{Type: messages.MsgError, Object: messages.ErrorResponse{WebError: errors.RequestNotJSON}})
+			continue
+		} else if data.Type == messages.MsgHello {
+			if !c.hello(data, c.sub.Send) {
+				log.Debugf("Closing connection %d of %s: unsupported protocol version\n", c.sub.ID, c.user.GetEmail())
+				// The write pump sends the buffered messages before closing the socket
+				c.sub.Close()
+			}
 			continue
 		}
-		c.user.HandleCommand(data)
+		c.user.HandleCommand(data, c.getProtocol(), c.sub.Send)
 	}
 }
 
@@ -89,22 +94,34 @@ func (c *connection) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
+		c.sub.Close()
 		c.ws.Close()
 	}()
 
+	// The network data is written directly instead of through the subscription,
+	// as it may not fit in the buffer with the replayed messages.
+	for _, msg := range append(append([]messages.Container{hello()}, c.start()...), c.networkData()...) {
+		err := c.writeJSON(c.encode(msg))
+		if err != nil {
+			log.Debugln("Disconnected while replaying:", err)
+			return
+		}
+	}
+
 	for {
 		select {
-		case new, ok := <-c.user.GetMessageChan():
+		case new, ok := <-c.sub.C():
 			if !ok {
+				log.Debugf("Closing connection %d of %s: subscription closed\n", c.sub.ID, c.user.GetEmail())
 				c.write(websocket.CloseMessage, []byte{})
-				c.user.SendMessage(new)
 				return
+			} else if c.alreadyReplayed(new) {
+				continue
 			}
 
-			err := c.writeJSON(new)
+			err := c.writeJSON(c.encode(new))
 			if err != nil {
 				log.Debugln("Disconnected:", err)
-				c.user.SendMessage(new)
 				return
 			}
 		case <-ticker.C:
@@ -116,7 +133,8 @@ func (c *connection) writePump() {
 	}
 }
 
-// Serve the WebSocket upgrader
+// Serve the WebSocket upgrader. The protocol parameter selects the protocol
+// version of the messages until the client sends a hello.
 func Serve(w http.ResponseWriter, r *http.Request) {
 	success, user := auth.Check(w, r)
 	if !success {
@@ -125,22 +143,33 @@ func Serve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	protocol, ok := messages.ParseProtocol(r.URL.Query().Get("protocol"))
+	if !ok {
+		errors.Write(w, errors.UnsupportedVersion)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Warnln("Failed to connect:", err)
 		return
 	}
-	c := &connection{ws: ws, user: user}
+	c := &connection{ws: ws, session: newSession(user, clientName(r, "websocket"), r.URL.Query(), protocol)}
+	log.Debugf("%s connected to socket as %s (connection %d, resume source: %s)\n", util.GetIP(r), user.GetEmail(), c.sub.ID, c.resume.Source)
 
 	c.ws.SetReadLimit(maxMessageSize)
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error { c.ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 
 	go c.writePump()
-
-	c.user.GetNetworks().ForEach(func(net interfaces.Network) {
-		c.user.SendNetworkData(net)
-	})
-
 	c.readPump()
 }
+
+// clientName creates a human-readable name for the client that sent the given request
+func clientName(r *http.Request, transport string) string {
+	name := util.GetIP(r) + " (" + transport + ")"
+	if ua := r.UserAgent(); len(ua) > 0 {
+		name += " " + ua
+	}
+	return name
+}
@@ -18,8 +18,8 @@
 package util
 
 import (
+	"net"
 	"net/http"
-	"strings"
 
 	"maunium.net/go/mauirc-server/interfaces"
 )
@@ -36,5 +36,9 @@ func GetIP(r *http.Request) string {
 	if config.TrustHeaders() {
 		return r.Header.Get("X-Forwarded-For")
 	}
-	return strings.Split(r.RemoteAddr, ":")[0]
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
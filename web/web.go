@@ -18,6 +18,7 @@
 package web
 
 import (
+	"expvar"
 	"net/http"
 	"os"
 
@@ -41,22 +42,46 @@ func Load(c interfaces.Configuration) {
 	misc.Init(config)
 	util.Init(config)
 
-	http.HandleFunc("/history/", misc.History)
-	http.HandleFunc("/script/", misc.Script)
-	http.HandleFunc("/network/", misc.Network)
-	http.HandleFunc("/settings/", misc.Settings)
-	http.HandleFunc("/auth/login", auth.Login)
-	http.HandleFunc("/auth/confirm", auth.EmailConfirm)
-	http.HandleFunc("/auth/password/reset", auth.PasswordReset)
-	http.HandleFunc("/auth/password/forgot", auth.PasswordForgot)
-	http.HandleFunc("/auth/password/change", auth.PasswordChange)
-	http.HandleFunc("/auth/register", auth.Register)
-	http.HandleFunc("/auth/check", auth.HTTPCheck)
-	http.HandleFunc("/socket", socket.Serve)
-	err := http.ListenAndServe(config.GetAddr(), context.ClearHandler(http.DefaultServeMux))
+	if len(config.GetMetricsAddr()) > 0 {
+		go serveMetrics(config.GetMetricsAddr())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/history/", misc.History)
+	mux.HandleFunc("/search", misc.Search)
+	mux.HandleFunc("/export/", misc.Export)
+	mux.HandleFunc("/import/", misc.Import)
+	mux.HandleFunc("/script/", misc.Script)
+	mux.HandleFunc("/network/", misc.Network)
+	mux.HandleFunc("/settings/", misc.Settings)
+	mux.HandleFunc("/clients", misc.Clients)
+	mux.HandleFunc("/schema", misc.Schema)
+	mux.HandleFunc("/auth/login", auth.Login)
+	mux.HandleFunc("/auth/confirm", auth.EmailConfirm)
+	mux.HandleFunc("/auth/password/reset", auth.PasswordReset)
+	mux.HandleFunc("/auth/password/forgot", auth.PasswordForgot)
+	mux.HandleFunc("/auth/password/change", auth.PasswordChange)
+	mux.HandleFunc("/auth/register", auth.Register)
+	mux.HandleFunc("/auth/check", auth.HTTPCheck)
+	mux.HandleFunc("/socket", socket.Serve)
+	mux.HandleFunc("/events", socket.Events)
+	mux.HandleFunc("/poll", socket.Poll)
+	mux.HandleFunc("/command", socket.Command)
+	err := http.ListenAndServe(config.GetAddr(), context.ClearHandler(mux))
 	if err != nil {
 		log.Fatalf("Failed to listen to %s: %s", config.GetAddr(), err)
 		log.Parent.Close()
 		os.Exit(4)
 	}
 }
+
+// serveMetrics serves the expvar metrics on a separate listener, so they're never exposed on the public address
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Debugln("Serving metrics on", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Errorf("Failed to listen to %s for metrics: %s\n", addr, err)
+	}
+}